@@ -1,19 +1,23 @@
 package main
 
 import (
-	"log"
 	"net/http"
 	"os"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	"github.com/ncskier/webhook-extension/endpoints"
 )
 
 func main() {
+	logger := endpoints.Logger()
+	defer logger.Sync()
+	defer endpoints.InitTracing("webhooks-extension-listener")()
+
 	// Create/setup resource
 	r, err := endpoints.NewResource()
 	if err != nil {
-		log.Fatalf("Fatal error creating resource: %s", err.Error())
+		logger.Fatalf("Fatal error creating resource: %s", err.Error())
 	}
 
 	// Set up routes
@@ -23,15 +27,24 @@ func main() {
 	// Add liveness/readiness
 	wsContainer.Add(endpoints.LivenessWebService())
 	wsContainer.Add(endpoints.ReadinessWebService())
+	// Add metrics
+	endpoints.RegisterMetrics(wsContainer)
+
+	// Start queued PipelineRuns as earlier runs complete
+	go r.ProcessRunQueue(30 * time.Second)
+	// Forget delivery IDs once they are past their TTL
+	go r.CleanupDeliveries(10 * time.Minute)
+	// Report on PipelineRuns as they complete
+	go r.WatchPipelineRuns(15 * time.Second)
 
 	// Serve
-	log.Print("Creating server and entering wait loop")
+	logger.Info("Creating server and entering wait loop")
 	port := ":8080"
 	portnum := os.Getenv("PORT")
 	if portnum != "" {
 		port = ":" + portnum
-		log.Printf("Port number from config: %s", portnum)
+		logger.Infof("Port number from config: %s", portnum)
 	}
 	server := &http.Server{Addr: port, Handler: wsContainer}
-	log.Fatal(server.ListenAndServe())
+	logger.Fatal(server.ListenAndServe())
 }
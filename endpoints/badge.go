@@ -0,0 +1,169 @@
+package endpoints
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	lru "github.com/hashicorp/golang-lru"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// How long a badge is served from the cache, and how long clients may cache it
+const badgeCacheDuration = 30 * time.Second
+
+// Anyone can ask for the badge of any branch, so only this many badges are cached, dropping the least recently used
+const badgeCacheSize = 1024
+
+// Label on the left of the badge
+const badgeLabel = "build"
+
+// Badge is the status of a webhook's most recent run for a branch, in the form of a shields.io endpoint badge
+type Badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// What the badge says for each status of a run, and its colour
+var badgeMessages = map[string]string{
+	runPending:   "pending",
+	runRunning:   "running",
+	runSucceeded: "passing",
+	runFailed:    "failing",
+	runCancelled: "cancelled",
+}
+
+var badgeColors = map[string]string{
+	"pending":   "#dfb317",
+	"running":   "#dfb317",
+	"passing":   "#4c1",
+	"failing":   "#e05d44",
+	"cancelled": "#9f9f9f",
+	"unknown":   "#9f9f9f",
+}
+
+// Badges are public, so they are cached rather than listing PipelineRuns for every request
+type cachedBadge struct {
+	badge   Badge
+	expires time.Time
+}
+
+var badgeCache, _ = lru.New(badgeCacheSize)
+
+/* Returns the badge for a webhook's most recent run on a branch, or any branch when none is given.
+Runs for pull requests are left out, they say nothing about the branch. Returns false when there is no badge to show */
+func (r Resource) getBadge(name, branch string) (Badge, bool, error) {
+	key := name + "\x00" + branch
+	if cached, ok := badgeCache.Get(key); ok && time.Now().Before(cached.(cachedBadge).expires) {
+		return cached.(cachedBadge).badge, true, nil
+	}
+
+	namespace := getPipelineRunNamespace()
+	webhook, ok := r.readGitHubWebhook(namespace)[name]
+	if !ok || webhook.DisableBadge {
+		return Badge{}, false, nil
+	}
+	run, err := r.latestBranchRun(name, namespace, branch)
+	if err != nil {
+		return Badge{}, false, err
+	}
+	badge := Badge{SchemaVersion: 1, Label: badgeLabel, Message: "unknown"}
+	if run != nil {
+		badge.Message = badgeMessages[run.Status]
+	}
+	badge.Color = badgeColors[badge.Message]
+
+	badgeCache.Add(key, cachedBadge{badge: badge, expires: time.Now().Add(badgeCacheDuration)})
+	return badge, true, nil
+}
+
+// latestBranchRun returns the summary of the webhook's most recent run that isn't for a pull request, or nil if there is none.
+// The selector leaves out runs for pull requests and other branches, so only the runs for the badge are listed
+func (r Resource) latestBranchRun(name, namespace, branch string) (*RunSummary, error) {
+	selector := fmt.Sprintf("%s,!%s", runsSelector(name, branch, "", ""), gitPullRequestLabel)
+	list, err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	var latest *RunSummary
+	var created metav1.Time
+	for _, pipelineRun := range list.Items {
+		if pipelineRunWebhook(pipelineRun) != name {
+			continue
+		}
+		summary := summarizeRun(pipelineRun)
+		if (branch != "" && summary.Branch != branch) || summary.PullRequest != "" {
+			continue
+		}
+		if latest == nil || created.Before(&pipelineRun.CreationTimestamp) {
+			latest, created = &summary, pipelineRun.CreationTimestamp
+		}
+	}
+	return latest, nil
+}
+
+// Approximate width in pixels of text in 11px Verdana, the badge font
+func badgeTextWidth(text string) int {
+	return len(text)*7 + 10
+}
+
+// renderBadge draws a badge as a flat SVG in the style of shields.io
+func renderBadge(badge Badge) string {
+	labelWidth := badgeTextWidth(badge.Label)
+	messageWidth := badgeTextWidth(badge.Message)
+	width := labelWidth + messageWidth
+	label := html.EscapeString(badge.Label)
+	message := html.EscapeString(badge.Message)
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`, width, label, message)
+	fmt.Fprintf(&svg, `<title>%s: %s</title>`, label, message)
+	svg.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&svg, `<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`, width)
+	fmt.Fprintf(&svg, `<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`,
+		labelWidth, labelWidth, messageWidth, badge.Color, width)
+	svg.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	fmt.Fprintf(&svg, `<text x="%d" y="14">%s</text><text x="%d" y="14">%s</text></g></svg>`, labelWidth/2, label, labelWidth+messageWidth/2, message)
+	return svg.String()
+}
+
+// Responds with the badge for the request, or an error. Returns false if there was no badge to respond with
+func (r Resource) respondBadge(request *restful.Request, response *restful.Response) (Badge, bool) {
+	name := request.PathParameter("name")
+	badge, ok, err := r.getBadge(name, request.QueryParameter("branch"))
+	if err != nil {
+		r.log().Errorf("could not get the badge for webhook %s: %s", name, err)
+		RespondErrorMessage(response, "could not get the badge", http.StatusInternalServerError)
+		return Badge{}, false
+	}
+	// Webhooks without badges look the same as missing webhooks, so private repositories aren't given away
+	if !ok {
+		RespondErrorMessage(response, fmt.Sprintf("no badge for webhook %s", name), http.StatusNotFound)
+		return Badge{}, false
+	}
+	response.AddHeader("Cache-Control", fmt.Sprintf("max-age=%d", int(badgeCacheDuration.Seconds())))
+	return badge, true
+}
+
+// getBadgeSVG serves the status badge of a webhook's most recent run for the branch query parameter
+func (r Resource) getBadgeSVG(request *restful.Request, response *restful.Response) {
+	badge, ok := r.respondBadge(request, response)
+	if !ok {
+		return
+	}
+	response.AddHeader("Content-Type", "image/svg+xml")
+	response.Write([]byte(renderBadge(badge)))
+}
+
+// getBadgeJSON serves the status badge as JSON, which can also be given to shields.io as an endpoint badge
+func (r Resource) getBadgeJSON(request *restful.Request, response *restful.Response) {
+	badge, ok := r.respondBadge(request, response)
+	if !ok {
+		return
+	}
+	response.WriteEntity(badge)
+}
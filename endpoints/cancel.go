@@ -0,0 +1,79 @@
+package endpoints
+
+import (
+	"fmt"
+
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// supersededAnnotation lists the PipelineRuns a run cancelled when it was created
+const supersededAnnotation = "webhooks.tekton.dev/superseded"
+
+// supersededSelector selects the listener's PipelineRuns for the same webhook and pull request,
+// or for pushes, the same branch outside of any pull request
+func supersededSelector(webhook Webhook, buildInformation BuildInformation) string {
+	selector := fmt.Sprintf("app=devops-knative,%s=%s", webhookLabel, labelValue(webhook.Name))
+	if buildInformation.PULLREQUEST != "" {
+		return fmt.Sprintf("%s,%s=%s", selector, gitPullRequestLabel, buildInformation.PULLREQUEST)
+	}
+	return fmt.Sprintf("%s,%s=%s,!%s", selector, gitBranchLabel, labelValue(buildInformation.BRANCH), gitPullRequestLabel)
+}
+
+// isPipelineRunDone returns true if the PipelineRun has succeeded, failed or been cancelled
+func isPipelineRunDone(pipelineRun v1alpha1.PipelineRun) bool {
+	if pipelineRun.Spec.Status == v1alpha1.PipelineRunSpecStatusCancelled {
+		return true
+	}
+	condition := pipelineRun.Status.GetCondition(duckv1alpha1.ConditionSucceeded)
+	return condition != nil && condition.Status != corev1.ConditionUnknown
+}
+
+/* Cancel the in-flight PipelineRuns the new run replacement for the same pull request (or branch, for pushes) makes obsolete.
+Returns the names of the cancelled PipelineRuns, failures are logged and skipped */
+func (r Resource) cancelSupersededPipelineRuns(webhook Webhook, buildInformation BuildInformation, namespace, replacement string) []string {
+	if buildInformation.PULLREQUEST == "" && buildInformation.BRANCH == "" {
+		return nil
+	}
+	selector := supersededSelector(webhook, buildInformation)
+	r.log().Infof("Looking for superseded PipelineRuns in namespace %s with selector %s", namespace, selector)
+	branch := ""
+	if buildInformation.PULLREQUEST == "" {
+		branch = buildInformation.BRANCH
+	}
+	return r.cancelPipelineRuns(selector, namespace, replacement, branch)
+}
+
+// isForBranch returns true if a PipelineRun was created for the branch, compared in full as different branches
+// can have the same label value. Every PipelineRun is for an empty branch
+func isForBranch(pipelineRun v1alpha1.PipelineRun, branch string) bool {
+	return branch == "" || summarizeRun(pipelineRun).Branch == branch
+}
+
+// Cancel the unfinished PipelineRuns matching a label selector and for the branch if one is given,
+// other than the one named keep, returning their names
+func (r Resource) cancelPipelineRuns(selector, namespace, keep, branch string) []string {
+	pipelineRuns := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace)
+	list, err := pipelineRuns.List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		r.log().Errorf("could not list PipelineRuns to cancel, error: %s", err)
+		return nil
+	}
+
+	cancelled := []string{}
+	for _, pipelineRun := range list.Items {
+		if pipelineRun.Name == keep || isPipelineRunDone(pipelineRun) || !isForBranch(pipelineRun, branch) {
+			continue
+		}
+		pipelineRun.Spec.Status = v1alpha1.PipelineRunSpecStatusCancelled
+		if _, err := pipelineRuns.Update(&pipelineRun); err != nil {
+			r.log().Errorf("could not cancel PipelineRun %s, error: %s", pipelineRun.Name, err)
+			continue
+		}
+		r.log().Infof("Cancelled PipelineRun %s", pipelineRun.Name)
+		cancelled = append(cancelled, pipelineRun.Name)
+	}
+	return cancelled
+}
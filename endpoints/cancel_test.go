@@ -0,0 +1,29 @@
+package endpoints
+
+import (
+	"testing"
+
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsForBranch(t *testing.T) {
+	pipelineRun := v1alpha1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{}}}
+	addEventLabels(&pipelineRun, Webhook{Name: "test-webhook"}, BuildInformation{BRANCH: "feature/a"})
+	addEventAnnotations(&pipelineRun, Webhook{Name: "test-webhook"}, BuildInformation{BRANCH: "feature/a"})
+
+	tests := []struct {
+		branch string
+		want   bool
+	}{
+		{"feature/a", true},
+		// Same label value, different branch
+		{"feature-a", false},
+		{"", true},
+	}
+	for _, test := range tests {
+		if got := isForBranch(pipelineRun, test.branch); got != test.want {
+			t.Errorf("isForBranch(%q) = %t, want %t", test.branch, got, test.want)
+		}
+	}
+}
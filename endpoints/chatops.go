@@ -0,0 +1,247 @@
+package endpoints
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// issueCommentPayload is the part of a GitHub issue_comment event ChatOps needs,
+// the vendored payload type has no issue.pull_request to tell pull request comments apart
+type issueCommentPayload struct {
+	Action string `json:"action"`
+	Issue  struct {
+		Number      int64 `json:"number"`
+		PullRequest *struct {
+			URL string `json:"url"`
+		} `json:"pull_request"`
+	} `json:"issue"`
+	Comment struct {
+		Body string `json:"body"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"comment"`
+	Repository struct {
+		Name    string `json:"name"`
+		HTMLURL string `json:"html_url"`
+	} `json:"repository"`
+}
+
+// Slash commands accepted in pull request comments
+const retestCommand = "/retest"
+const testCommand = "/test"
+const cancelCommand = "/cancel"
+const okToTestCommand = "/ok-to-test"
+
+// parseCommand returns the first slash command in a comment and its argument, e.g. /test my-webhook
+func parseCommand(body string) (command, argument string) {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case retestCommand, cancelCommand, okToTestCommand:
+			return fields[0], ""
+		case testCommand:
+			if len(fields) > 1 {
+				return fields[0], fields[1]
+			}
+		}
+	}
+	return "", ""
+}
+
+// buildInformationForPullRequest returns the information to build the head of a pull request
+func buildInformationForPullRequest(repoURL, repoName string, pull PullRequest) (BuildInformation, error) {
+	if len(pull.HeadSha) < 7 {
+		return BuildInformation{}, fmt.Errorf("pull request %s has no valid head commit", pull.Number)
+	}
+	return BuildInformation{
+		REPOURL:     repoURL,
+		SHORTID:     pull.HeadSha[0:7],
+		COMMITID:    pull.HeadSha,
+		REPONAME:    repoName,
+		TIMESTAMP:   getDateTimeAsString(),
+		BRANCH:      pull.HeadRef,
+		PULLREQUEST: pull.Number,
+		PRTITLE:     pull.Title,
+		FORK:        pull.Fork,
+		PRLABELS:    pull.Labels,
+	}, nil
+}
+
+// pullRequestSelector selects the listener's PipelineRuns for a pull request of a repository
+func pullRequestSelector(repoURL, number string) (string, error) {
+	_, gitOrg, gitRepo, err := getGitValues(repoURL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("app=devops-knative,%s=%s,%s=%s,%s=%s", gitOrgLabel, labelValue(gitOrg), gitRepoLabel, labelValue(gitRepo),
+		gitPullRequestLabel, number), nil
+}
+
+// isChatOpsUser returns true if the user is on the webhook's allowlist or can write to the repository
+func (r Resource) isChatOpsUser(webhook Webhook, provider GitProvider, repoURL, user string) bool {
+	for _, allowed := range webhook.ChatOpsUsers {
+		if allowed == user {
+			return true
+		}
+	}
+	permission, err := provider.GetPermission(repoURL, user)
+	if err != nil {
+		r.log().Errorf("could not get the permission of %s on %s: %s", user, repoURL, err)
+		return false
+	}
+	return permission == "admin" || permission == "write"
+}
+
+// findWebhookForRetest returns the webhook of the pull request's most recent PipelineRun, or else the repository's webhook
+func (r Resource) findWebhookForRetest(repoURL, number, namespace string) (Webhook, error) {
+	selector, err := pullRequestSelector(repoURL, number)
+	if err != nil {
+		return Webhook{}, err
+	}
+	list, err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err == nil && len(list.Items) > 0 {
+		latest := list.Items[0]
+		for _, pipelineRun := range list.Items {
+			if latest.CreationTimestamp.Before(&pipelineRun.CreationTimestamp) {
+				latest = pipelineRun
+			}
+		}
+		if webhook, ok := r.readGitHubWebhook(namespace)[pipelineRunWebhook(latest)]; ok {
+			return webhook, nil
+		}
+	}
+	return r.getGitHubWebhook(repoURL, namespace)
+}
+
+// handleIssueComment runs the slash command in a pull request comment for an allowed user
+func (r Resource) handleIssueComment(request *restful.Request, response *restful.Response) {
+	payload := issueCommentPayload{}
+	if err := request.ReadEntity(&payload); err != nil {
+		r.log().Errorf("an error occurred decoding webhook data: %s", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	if err := validateIssueCommentPayload(payload); err != nil {
+		r.log().Warnf("invalid issue comment event: %s", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	if payload.Action != "created" || payload.Issue.PullRequest == nil {
+		r.respondSkipped(response, "not a new pull request comment")
+		return
+	}
+	command, argument := parseCommand(payload.Comment.Body)
+	if command == "" {
+		r.respondSkipped(response, "comment has no command")
+		return
+	}
+
+	pipelineNs := getPipelineRunNamespace()
+	repoURL := payload.Repository.HTMLURL
+	number := strconv.FormatInt(payload.Issue.Number, 10)
+	user := payload.Comment.User.Login
+	r = r.withFields("repo", repoURL)
+	r.log().Infof("Handling %s from %s on pull request %s of %s", command, user, number, repoURL)
+
+	var webhook Webhook
+	var err error
+	if command == testCommand {
+		var ok bool
+		webhook, ok = r.readGitHubWebhook(pipelineNs)[argument]
+		if !ok || webhook.GitRepositoryURL != repoURL {
+			err = fmt.Errorf("no webhook named %s for %s", argument, repoURL)
+		}
+	} else {
+		webhook, err = r.findWebhookForRetest(repoURL, number, pipelineNs)
+	}
+	if err != nil {
+		r.log().Errorf("could not find the webhook for %s: %s", command, err)
+		r.respondSkipped(response, err.Error())
+		return
+	}
+	r.delivery.matched(webhook)
+	r = r.withFields("webhook", webhook.Name)
+
+	provider, err := r.getGitProvider(webhook)
+	if err != nil {
+		r.log().Errorf("could not create a Git provider client for webhook %s: %s", webhook.Name, err)
+		r.delivery.failed(err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	if !r.isChatOpsUser(webhook, provider, repoURL, user) {
+		reason := fmt.Sprintf("%s is not allowed to run %s", user, command)
+		r.log().Infof("%s is not allowed to run %s on %s", user, command, repoURL)
+		r.recordSkippedEvent(webhook, "issue_comment", "pull request "+number, reason)
+		r.delivery.skipped(reason)
+		RespondErrorMessage(response, reason, http.StatusForbidden)
+		return
+	}
+
+	if command == cancelCommand {
+		if r.dryRun != nil {
+			r.respondSkipped(response, "dry run, no PipelineRuns cancelled")
+			return
+		}
+		selector, err := pullRequestSelector(repoURL, number)
+		if err != nil {
+			r.log().Errorf("could not build the selector for pull request %s: %s", number, err)
+			r.delivery.failed(err)
+			RespondError(response, err, http.StatusInternalServerError)
+			return
+		}
+		cancelled := r.cancelPipelineRuns(selector, pipelineNs, "", "")
+		r.log().Infof("%s cancelled PipelineRuns %v", user, cancelled)
+		response.WriteHeaderAndJson(http.StatusOK, ListenerResponse{Cancelled: cancelled}, restful.MIME_JSON)
+		return
+	}
+
+	// Comments don't carry the commit, build whatever the pull request head is now
+	pull, err := provider.GetPullRequest(repoURL, number)
+	if err != nil {
+		r.log().Errorf("could not get pull request %s of %s: %s", number, repoURL, err)
+		r.delivery.failed(err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	buildInformation, err := buildInformationForPullRequest(repoURL, payload.Repository.Name, pull)
+	if err != nil {
+		r.log().Errorf("could not build pull request %s: %s", number, err)
+		r.delivery.failed(err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	buildInformation.SENDER = user
+	buildInformation.DELIVERYID = getDeliveryID(request)
+	// An allowed user asking for a build approves it, this is how pull requests from forks get built under the approve policy
+	buildInformation.APPROVED = true
+	skipReason := checkForkPolicy(webhook, buildInformation)
+	if r.dryRun == nil {
+		recordFilterDecision(webhook, skipReason)
+	}
+	if skipReason != "" {
+		r.recordSkippedEvent(webhook, "issue_comment", "pull request "+number, skipReason)
+		r.respondSkipped(response, skipReason)
+		return
+	}
+	pipelineRunName, err := r.startPipelineRun(webhook, buildInformation, pipelineNs)
+	if err != nil {
+		r.log().Errorf("could not start the PipelineRun for %s: %s", command, err)
+		r.delivery.failed(err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	// A dry run responds with the rendered PipelineRun instead
+	if r.dryRun == nil {
+		response.WriteHeaderAndJson(http.StatusOK, ListenerResponse{PipelineRun: pipelineRunName, Queued: pipelineRunName == ""}, restful.MIME_JSON)
+	}
+}
@@ -0,0 +1,510 @@
+package endpoints
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// checkRunIDAnnotation records the ID of the check run reporting a PipelineRun
+const checkRunIDAnnotation = "webhooks.tekton.dev/check-run-id"
+
+// checkRunOutputAnnotation records a digest of what was last sent to a PipelineRun's check run,
+// so that whichever replica looks next only updates the check run when the PipelineRun has moved on
+const checkRunOutputAnnotation = "webhooks.tekton.dev/check-run-output"
+
+// Value of the trigger label on PipelineRuns started by re-running their check run
+const rerunTrigger = "rerun"
+
+// Statuses and conclusions of check runs
+const (
+	checkRunQueued     = "queued"
+	checkRunInProgress = "in_progress"
+	checkRunCompleted  = "completed"
+	checkRunSuccess    = "success"
+	checkRunFailure    = "failure"
+	checkRunCancelled  = "cancelled"
+)
+
+// Status of a PipelineTask that didn't run because its PipelineRun finished first
+const taskSkipped = "skipped"
+
+// GitHub takes at most 65535 characters of check run text, the last lines of each failed step are what is useful
+const maxCheckRunTextLength = 65535
+const failedStepLogLines = 100
+
+// checkRunPayload is the part of a GitHub check_run event needed to re-run a check
+type checkRunPayload struct {
+	Action   string `json:"action"`
+	CheckRun struct {
+		ID         int64  `json:"id"`
+		Name       string `json:"name"`
+		HeadSHA    string `json:"head_sha"`
+		ExternalID string `json:"external_id"`
+		CheckSuite struct {
+			HeadBranch   string `json:"head_branch"`
+			PullRequests []struct {
+				Number int64 `json:"number"`
+			} `json:"pull_requests"`
+		} `json:"check_suite"`
+	} `json:"check_run"`
+	Repository struct {
+		Name    string `json:"name"`
+		HTMLURL string `json:"html_url"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+// taskResult is how one PipelineTask of a PipelineRun is doing
+type taskResult struct {
+	Name     string
+	Status   string
+	Message  string
+	PodName  string
+	Duration time.Duration
+}
+
+// Returns the name of the check run for a webhook's pipeline, the name is shown on the commit and pull request
+func checkRunName(webhook Webhook, pipelineRun v1alpha1.PipelineRun) string {
+	return fmt.Sprintf("%s (%s)", webhook.Name, pipelineRun.Spec.PipelineRef.Name)
+}
+
+// taskRunStatus returns the status of a PipelineTask's TaskRun and the message of its condition
+func taskRunStatus(status *v1alpha1.TaskRunStatus) (string, string) {
+	if status == nil {
+		return runPending, ""
+	}
+	condition := status.GetCondition(duckv1alpha1.ConditionSucceeded)
+	if condition == nil {
+		if status.StartTime == nil {
+			return runPending, ""
+		}
+		return runRunning, ""
+	}
+	switch condition.Status {
+	case corev1.ConditionTrue:
+		return runSucceeded, condition.Message
+	case corev1.ConditionFalse:
+		return runFailed, condition.Message
+	}
+	return runRunning, condition.Message
+}
+
+// pipelineTaskResults returns how each PipelineTask of a PipelineRun is doing, in the order of its Pipeline.
+// Tasks that haven't started have no TaskRun yet, so the Pipeline is looked up to list them too
+func (r Resource) pipelineTaskResults(pipelineRun v1alpha1.PipelineRun) []taskResult {
+	results := map[string]taskResult{}
+	for _, taskRun := range pipelineRun.Status.TaskRuns {
+		if taskRun == nil {
+			continue
+		}
+		result := taskResult{Name: taskRun.PipelineTaskName}
+		result.Status, result.Message = taskRunStatus(taskRun.Status)
+		if taskRun.Status != nil {
+			result.PodName = taskRun.Status.PodName
+			if taskRun.Status.StartTime != nil && taskRun.Status.CompletionTime != nil {
+				result.Duration = taskRun.Status.CompletionTime.Sub(taskRun.Status.StartTime.Time)
+			}
+		}
+		results[result.Name] = result
+	}
+
+	names := []string{}
+	listed := map[string]bool{}
+	if pipeline, err := r.getPipelineImpl(pipelineRun.Spec.PipelineRef.Name, pipelineRun.Namespace); err == nil {
+		for _, task := range pipeline.Spec.Tasks {
+			names = append(names, task.Name)
+			listed[task.Name] = true
+		}
+	}
+	// Should the Pipeline be gone or have changed, its TaskRuns are still listed
+	unlisted := []string{}
+	for name := range results {
+		if !listed[name] {
+			unlisted = append(unlisted, name)
+		}
+	}
+	sort.Strings(unlisted)
+	names = append(names, unlisted...)
+
+	done := isPipelineRunDone(pipelineRun)
+	tasks := []taskResult{}
+	for _, name := range names {
+		result, ok := results[name]
+		if !ok {
+			result = taskResult{Name: name, Status: runPending}
+		}
+		if done && (result.Status == runPending || result.Status == runRunning) {
+			result.Status = taskSkipped
+		}
+		tasks = append(tasks, result)
+	}
+	return tasks
+}
+
+// Returns the title of a check run, e.g. "2 of 3 tasks complete" or "Task build failed"
+func checkRunTitle(status, reason string, tasks []taskResult) string {
+	complete := 0
+	failed := []string{}
+	for _, task := range tasks {
+		switch task.Status {
+		case runSucceeded:
+			complete++
+		case runFailed:
+			complete++
+			failed = append(failed, task.Name)
+		}
+	}
+	switch {
+	case status == runPending:
+		return "Waiting to start"
+	case status == runCancelled:
+		return "Cancelled"
+	case len(failed) == 1:
+		return fmt.Sprintf("Task %s failed", failed[0])
+	case len(failed) > 1:
+		return fmt.Sprintf("Tasks %s failed", strings.Join(failed, ", "))
+	case status == runFailed:
+		if reason != "" {
+			return "Failed: " + reason
+		}
+		return "Failed"
+	case status == runSucceeded && len(tasks) > 0:
+		return fmt.Sprintf("All %d tasks succeeded", len(tasks))
+	case status == runSucceeded:
+		return "Succeeded"
+	case len(tasks) == 0:
+		return "Running"
+	}
+	return fmt.Sprintf("%d of %d tasks complete", complete, len(tasks))
+}
+
+// Returns the summary of a check run, a table of the PipelineRun's tasks in Markdown
+func checkRunSummary(pipelineRun v1alpha1.PipelineRun, message string, tasks []taskResult) string {
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "PipelineRun `%s` of pipeline `%s`\n", pipelineRun.Name, pipelineRun.Spec.PipelineRef.Name)
+	if message != "" {
+		fmt.Fprintf(&summary, "\n%s\n", message)
+	}
+	if len(tasks) == 0 {
+		return summary.String()
+	}
+	summary.WriteString("\n| Task | Status | Duration |\n| --- | --- | --- |\n")
+	for _, task := range tasks {
+		duration := ""
+		if task.Duration > 0 {
+			duration = task.Duration.Round(time.Second).String()
+		}
+		fmt.Fprintf(&summary, "| %s | %s | %s |\n", task.Name, task.Status, duration)
+	}
+	return summary.String()
+}
+
+// newCheckRun describes a webhook's PipelineRun as a check run on the commit it is for, without the output of failed tasks
+func newCheckRun(webhook Webhook, pipelineRun v1alpha1.PipelineRun, tasks []taskResult) CheckRun {
+	status, reason, message := runStatus(pipelineRun)
+	run := summarizeRun(pipelineRun)
+	checkRun := CheckRun{
+		Name:       checkRunName(webhook, pipelineRun),
+		HeadSHA:    run.Commit,
+		ExternalID: pipelineRun.Name,
+		Status:     checkRunInProgress,
+		Title:      checkRunTitle(status, reason, tasks),
+		Summary:    checkRunSummary(pipelineRun, message, tasks),
+	}
+	// Without the dashboard there is nowhere better to link to than GitHub's own page for the check
+	if os.Getenv("DASHBOARD_URL") != "" {
+		checkRun.DetailsURL = runLink(webhook.GitRepositoryURL, run.Commit, pipelineRun.Namespace, pipelineRun.Name)
+	}
+	if start := pipelineRun.Status.StartTime; start != nil {
+		checkRun.StartedAt = start.Time
+	}
+	switch status {
+	case runPending:
+		checkRun.Status = checkRunQueued
+	case runSucceeded, runFailed, runCancelled:
+		checkRun.Status = checkRunCompleted
+		checkRun.Conclusion = map[string]string{runSucceeded: checkRunSuccess, runFailed: checkRunFailure, runCancelled: checkRunCancelled}[status]
+		checkRun.CompletedAt = time.Now()
+		if completion := pipelineRun.Status.CompletionTime; completion != nil {
+			checkRun.CompletedAt = completion.Time
+		}
+	}
+	return checkRun
+}
+
+// Returns the logs of the steps that failed in a TaskRun's pod, or "" if there are none to get
+func (r Resource) failedStepLogs(podName, namespace string) string {
+	if podName == "" {
+		return ""
+	}
+	pods := r.K8sClient.CoreV1().Pods(namespace)
+	pod, err := pods.Get(podName, metav1.GetOptions{})
+	if err != nil {
+		r.log().Warnf("could not get pod %s for the output of its failed steps, error: %s", podName, err)
+		return ""
+	}
+	var logs strings.Builder
+	tailLines := int64(failedStepLogLines)
+	for _, container := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if container.State.Terminated == nil || container.State.Terminated.ExitCode == 0 {
+			continue
+		}
+		output, err := pods.GetLogs(podName, &corev1.PodLogOptions{Container: container.Name, TailLines: &tailLines}).Do().Raw()
+		if err != nil {
+			r.log().Warnf("could not get the logs of step %s in pod %s, error: %s", container.Name, podName, err)
+			continue
+		}
+		fmt.Fprintf(&logs, "#### %s (exit code %d)\n```\n%s\n```\n", container.Name, container.State.Terminated.ExitCode, strings.TrimRight(string(output), "\n"))
+	}
+	return logs.String()
+}
+
+// failedTaskOutput returns the message of each failed task, with its failed step logs when the webhook shares them,
+// as check run text in Markdown
+func (r Resource) failedTaskOutput(webhook Webhook, tasks []taskResult, namespace string) string {
+	var text strings.Builder
+	for _, task := range tasks {
+		if task.Status != runFailed {
+			continue
+		}
+		fmt.Fprintf(&text, "### %s\n", task.Name)
+		if task.Message != "" {
+			fmt.Fprintf(&text, "%s\n", task.Message)
+		}
+		// Check runs can be seen by anyone who can see the repository, and logs can hold whatever the steps printed
+		if webhook.CheckRunLogs {
+			text.WriteString(r.failedStepLogs(task.PodName, namespace))
+		}
+	}
+	return truncateUTF8(text.String(), maxCheckRunTextLength)
+}
+
+// checkRunOutput returns a digest of the status and output of a check run, to record on its PipelineRun
+func checkRunOutput(checkRun CheckRun) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{checkRun.Status, checkRun.Conclusion, checkRun.Title, checkRun.Summary}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// createCheckRun creates the check run reporting a webhook's new PipelineRun and records its ID on the PipelineRun,
+// failures are logged. Only PipelineRuns for a commit get one, release events only have a tag
+func (r Resource) createCheckRun(webhook Webhook, pipelineRun v1alpha1.PipelineRun) {
+	if !webhook.CheckRuns || !commitIDPattern.MatchString(pipelineRun.Annotations[commitAnnotation]) {
+		return
+	}
+	provider, err := r.getGitProvider(webhook)
+	if err != nil {
+		r.log().Errorf("could not create a Git provider client for the check run of PipelineRun %s, error: %s", pipelineRun.Name, err)
+		return
+	}
+	checkRun := newCheckRun(webhook, pipelineRun, r.pipelineTaskResults(pipelineRun))
+	id, err := provider.CreateCheckRun(webhook.GitRepositoryURL, checkRun)
+	if err != nil {
+		r.log().Errorf("could not create the check run for PipelineRun %s, error: %s", pipelineRun.Name, err)
+		return
+	}
+
+	// The Tekton controller updates the run's status at the same time, so the annotation is retried on conflicts
+	pipelineRuns := r.TektonClient.TektonV1alpha1().PipelineRuns(pipelineRun.Namespace)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := pipelineRuns.Get(pipelineRun.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.Annotations == nil {
+			latest.Annotations = map[string]string{}
+		}
+		latest.Annotations[checkRunIDAnnotation] = strconv.FormatInt(id, 10)
+		latest.Annotations[checkRunOutputAnnotation] = checkRunOutput(checkRun)
+		_, err = pipelineRuns.Update(latest)
+		return err
+	})
+	if err != nil {
+		r.log().Errorf("could not record check run %d on PipelineRun %s, its progress won't be reported, error: %s", id, pipelineRun.Name, err)
+		return
+	}
+	r.log().Infof("Created check run %d for PipelineRun %s", id, pipelineRun.Name)
+}
+
+// updateCheckRun reports the progress of a webhook's PipelineRun on its check run, if it has one and has moved on
+func (r Resource) updateCheckRun(webhook Webhook, pipelineRun v1alpha1.PipelineRun) {
+	value := pipelineRun.Annotations[checkRunIDAnnotation]
+	if value == "" {
+		return
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		r.log().Errorf("PipelineRun %s has an invalid check run ID %q", pipelineRun.Name, value)
+		return
+	}
+	tasks := r.pipelineTaskResults(pipelineRun)
+	checkRun := newCheckRun(webhook, pipelineRun, tasks)
+	output := checkRunOutput(checkRun)
+	if pipelineRun.Annotations[checkRunOutputAnnotation] == output {
+		return
+	}
+	checkRun.Text = r.failedTaskOutput(webhook, tasks, pipelineRun.Namespace)
+
+	provider, err := r.getGitProvider(webhook)
+	if err != nil {
+		r.log().Errorf("could not create a Git provider client for check run %d, error: %s", id, err)
+		return
+	}
+	if err := provider.UpdateCheckRun(webhook.GitRepositoryURL, id, checkRun); err != nil {
+		r.log().Errorf("could not update check run %d of PipelineRun %s, error: %s", id, pipelineRun.Name, err)
+		return
+	}
+	if err := r.annotatePipelineRun(pipelineRun.Name, pipelineRun.Namespace, checkRunOutputAnnotation, output); err != nil {
+		r.log().Warnf("could not record the update of check run %d on PipelineRun %s, it will be sent again, error: %s", id, pipelineRun.Name, err)
+	}
+	r.log().Debugf("Updated check run %d of PipelineRun %s: %s", id, pipelineRun.Name, checkRun.Title)
+}
+
+// buildInformationForRerun returns the webhook and information to build the commit of a re-requested check run.
+// The PipelineRun the check run reported says what the event was, the event itself only has the commit and branch
+func (r Resource) buildInformationForRerun(payload checkRunPayload, namespace string) (Webhook, BuildInformation, error) {
+	repoURL := payload.Repository.HTMLURL
+	var original *v1alpha1.PipelineRun
+	if name := payload.CheckRun.ExternalID; name != "" {
+		if pipelineRun, err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).Get(name, metav1.GetOptions{}); err == nil {
+			original = pipelineRun
+		}
+	}
+	var webhook Webhook
+	found := false
+	if original != nil {
+		webhook, found = r.readGitHubWebhook(namespace)[pipelineRunWebhook(*original)]
+		found = found && webhook.GitRepositoryURL == repoURL
+	}
+	if !found {
+		var err error
+		if webhook, err = r.getGitHubWebhook(repoURL, namespace); err != nil {
+			return Webhook{}, BuildInformation{}, err
+		}
+	}
+
+	sha := payload.CheckRun.HeadSHA
+	buildInformation := BuildInformation{
+		REPOURL:   repoURL,
+		SHORTID:   sha[0:7],
+		COMMITID:  sha,
+		REPONAME:  payload.Repository.Name,
+		TIMESTAMP: getDateTimeAsString(),
+		EVENTTYPE: "push",
+		BRANCH:    payload.CheckRun.CheckSuite.HeadBranch,
+	}
+	if pulls := payload.CheckRun.CheckSuite.PullRequests; len(pulls) > 0 {
+		buildInformation.EVENTTYPE = "pull_request"
+		buildInformation.PULLREQUEST = strconv.FormatInt(pulls[0].Number, 10)
+	}
+	if original != nil {
+		run := summarizeRun(*original)
+		if run.EventType != "" {
+			buildInformation.EVENTTYPE = run.EventType
+		}
+		buildInformation.BRANCH = run.Branch
+		buildInformation.TAG = run.Tag
+		buildInformation.PULLREQUEST = run.PullRequest
+		buildInformation.COMMITMESSAGE = original.Annotations[commitMessageAnnotation]
+	}
+
+	// Whether a pull request comes from a fork decides which service account builds it, so it is looked up rather than assumed
+	if buildInformation.PULLREQUEST != "" {
+		provider, err := r.getGitProvider(webhook)
+		if err != nil {
+			return Webhook{}, BuildInformation{}, err
+		}
+		pull, err := provider.GetPullRequest(repoURL, buildInformation.PULLREQUEST)
+		if err != nil {
+			return Webhook{}, BuildInformation{}, fmt.Errorf("could not get pull request %s: %s", buildInformation.PULLREQUEST, err)
+		}
+		buildInformation.BRANCH = pull.HeadRef
+		buildInformation.PRTITLE = pull.Title
+		buildInformation.FORK = pull.Fork
+		buildInformation.PRLABELS = pull.Labels
+	}
+	return webhook, buildInformation, nil
+}
+
+// handleCheckRun re-runs the pipeline of a check run someone asked GitHub to re-run
+func (r Resource) handleCheckRun(request *restful.Request, response *restful.Response) {
+	payload := checkRunPayload{}
+	if err := request.ReadEntity(&payload); err != nil {
+		r.log().Errorf("an error occurred decoding webhook data: %s", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	if err := validateCheckRunPayload(payload); err != nil {
+		r.log().Warnf("invalid check run event: %s", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	if payload.Action != "rerequested" {
+		r.respondSkipped(response, fmt.Sprintf("check run action %s is not built", payload.Action))
+		return
+	}
+
+	pipelineNs := getPipelineRunNamespace()
+	r = r.withFields("repo", payload.Repository.HTMLURL)
+	user := payload.Sender.Login
+	r.log().Infof("%s asked to re-run check run %d on %s", user, payload.CheckRun.ID, payload.CheckRun.HeadSHA)
+
+	webhook, buildInformation, err := r.buildInformationForRerun(payload, pipelineNs)
+	if err != nil {
+		r.log().Errorf("could not re-run check run %d: %s", payload.CheckRun.ID, err)
+		r.delivery.failed(err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	r.delivery.matched(webhook)
+	r = r.withFields("webhook", webhook.Name)
+
+	buildInformation.SENDER = user
+	buildInformation.DELIVERYID = getDeliveryID(request)
+	buildInformation.TRIGGER = rerunTrigger
+	buildInformation.TRIGGEREDBY = user
+	// The sender approves a pull request from a fork by re-running its check only if they could approve it with /ok-to-test
+	if buildInformation.FORK {
+		provider, err := r.getGitProvider(webhook)
+		if err != nil {
+			r.log().Errorf("could not create a Git provider client to check the permission of %s: %s", user, err)
+		} else {
+			buildInformation.APPROVED = r.isChatOpsUser(webhook, provider, buildInformation.REPOURL, user)
+		}
+	}
+	skipReason := checkForkPolicy(webhook, buildInformation)
+	if r.dryRun == nil {
+		recordFilterDecision(webhook, skipReason)
+	}
+	if skipReason != "" {
+		r.recordSkippedEvent(webhook, "check_run", buildInformation.COMMITID, skipReason)
+		r.respondSkipped(response, skipReason)
+		return
+	}
+	pipelineRunName, err := r.startPipelineRun(webhook, buildInformation, pipelineNs)
+	if err != nil {
+		r.log().Errorf("could not start the PipelineRun to re-run check run %d: %s", payload.CheckRun.ID, err)
+		r.delivery.failed(err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	// A dry run responds with the rendered PipelineRun instead
+	if r.dryRun == nil {
+		response.WriteHeaderAndJson(http.StatusOK, ListenerResponse{PipelineRun: pipelineRunName, Queued: pipelineRunName == ""}, restful.MIME_JSON)
+	}
+}
@@ -0,0 +1,228 @@
+package endpoints
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+const testAppID = "1234"
+
+// fakeGitHubAPI is a local GitHub API for a GitHub App installed on owner/repo, recording the check runs it is sent
+type fakeGitHubAPI struct {
+	key       *rsa.PublicKey
+	mutex     sync.Mutex
+	minted    int
+	checkRuns map[string]githubCheckRun
+}
+
+// verifyJWT returns true if the request is authenticated as the App, with a token signed by its private key
+func (f *fakeGitHubAPI) verifyJWT(request *http.Request) bool {
+	parts := strings.Split(strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer "), ".")
+	if len(parts) != 3 {
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(f.key, crypto.SHA256, digest[:], signature) != nil {
+		return false
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var decoded struct {
+		Issuer string `json:"iss"`
+		Expiry int64  `json:"exp"`
+	}
+	return json.Unmarshal(claims, &decoded) == nil && decoded.Issuer == testAppID && decoded.Expiry > time.Now().Unix()
+}
+
+func (f *fakeGitHubAPI) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	switch {
+	case request.URL.Path == "/repos/owner/repo/installation":
+		if !f.verifyJWT(request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id": 42}`))
+	case request.URL.Path == "/app/installations/42/access_tokens" && request.Method == http.MethodPost:
+		if !f.verifyJWT(request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.minted++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"token": "installation-token", "expires_at": time.Now().Add(time.Hour).Format(time.RFC3339)})
+	case strings.HasPrefix(request.URL.Path, "/repos/owner/repo/check-runs"):
+		if request.Header.Get("Authorization") != "token installation-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var checkRun githubCheckRun
+		if err := json.NewDecoder(request.Body).Decode(&checkRun); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if request.Method == http.MethodPost {
+			f.checkRuns["7"] = checkRun
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": 7}`))
+			return
+		}
+		f.checkRuns[strings.TrimPrefix(request.URL.Path, "/repos/owner/repo/check-runs/")] = checkRun
+	default:
+		http.NotFound(w, request)
+	}
+}
+
+func TestGitHubAppCheckRuns(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeGitHubAPI{key: &key.PublicKey, checkRuns: map[string]githubCheckRun{}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	os.Setenv("GITHUB_API_URL", server.URL)
+	defer os.Unsetenv("GITHUB_API_URL")
+
+	webhook := Webhook{Name: "test-webhook", Namespace: "default", GitRepositoryURL: "https://github.com/owner/repo", AccessTokenRef: "github-app"}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: webhook.AccessTokenRef, Namespace: webhook.Namespace},
+		Data: map[string][]byte{
+			"accessToken":          []byte("personal-access-token"),
+			githubAppIDKey:         []byte(testAppID),
+			githubAppPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		},
+	}
+	r := Resource{K8sClient: k8sfake.NewSimpleClientset(secret), GitProvider: NewGitHubProvider}
+
+	provider, err := r.getGitProvider(webhook)
+	if err != nil {
+		t.Fatalf("getGitProvider: %s", err)
+	}
+	id, err := provider.CreateCheckRun(webhook.GitRepositoryURL, CheckRun{Name: "test-webhook (build)", HeadSHA: testCommitID, Status: checkRunQueued})
+	if err != nil || id != 7 {
+		t.Fatalf("CreateCheckRun = %d, %v, want 7", id, err)
+	}
+	// The installation token is used until it is about to expire
+	provider, err = r.getGitProvider(webhook)
+	if err != nil {
+		t.Fatalf("getGitProvider: %s", err)
+	}
+	checkRun := CheckRun{Status: checkRunCompleted, Conclusion: checkRunFailure, Title: "Task build failed", Summary: "summary", Text: "logs"}
+	if err := provider.UpdateCheckRun(webhook.GitRepositoryURL, id, checkRun); err != nil {
+		t.Fatalf("UpdateCheckRun: %s", err)
+	}
+	if api.minted != 1 {
+		t.Errorf("minted %d installation tokens, want 1", api.minted)
+	}
+	updated := api.checkRuns["7"]
+	if updated.Conclusion != checkRunFailure || updated.Output == nil || updated.Output.Text != "logs" || updated.HeadSHA != "" {
+		t.Errorf("got check run %+v, want the update", updated)
+	}
+}
+
+func TestValidSignature(t *testing.T) {
+	payload := []byte(`{"action": "rerequested"}`)
+	tests := []struct {
+		name   string
+		header http.Header
+		secret string
+		want   bool
+	}{
+		{"sha256", http.Header{githubSignature256Header: []string{signPayload(payload, "secret")}}, "secret", true},
+		{"sha1", http.Header{githubSignatureHeader: []string{"sha1=2e831205f4e838820b554e77fb1d3756cd3a3aa6"}}, "secret", true},
+		{"wrong secret", http.Header{githubSignature256Header: []string{signPayload(payload, "other")}}, "secret", false},
+		{"unsigned", http.Header{}, "secret", false},
+		{"no secret", http.Header{githubSignature256Header: []string{signPayload(payload, "")}}, "", false},
+	}
+	for _, test := range tests {
+		if got := validSignature(payload, test.secret, test.header); got != test.want {
+			t.Errorf("%s: validSignature() = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+// signPayload returns the X-Hub-Signature-256 value GitHub sends for a payload signed with the secret
+func signPayload(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestReceiveGitHubDelivery(t *testing.T) {
+	r, webhook := newFakeProviderResource(&fakeGitProvider{})
+	secret, _ := r.K8sClient.CoreV1().Secrets(webhook.Namespace).Get(webhook.AccessTokenRef, metav1.GetOptions{})
+	secret.Data[secretTokenKey] = []byte("secret")
+	r.K8sClient.CoreV1().Secrets(webhook.Namespace).Update(secret)
+	r.writeGitHubWebhook(webhook.Namespace, map[string]Webhook{webhook.Name: webhook})
+
+	var forwarded []*http.Request
+	listener := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		forwarded = append(forwarded, request)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer listener.Close()
+	os.Setenv("LISTENER_URL", listener.URL)
+	defer os.Unsetenv("LISTENER_URL")
+
+	payload := `{"action": "rerequested", "repository": {"html_url": "https://github.com/owner/repo"}}`
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"signed", map[string]string{githubSignature256Header: signPayload([]byte(payload), "secret")}, http.StatusAccepted},
+		{"forged", map[string]string{githubSignature256Header: signPayload([]byte(payload), "guess")}, http.StatusUnauthorized},
+		{"unsigned", map[string]string{}, http.StatusUnauthorized},
+		{"unsigned as if from a GitHubSource", map[string]string{githubEventParameter: "check_run"}, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		forwarded = nil
+		httpRequest := httptest.NewRequest(http.MethodPost, "/webhook/github?namespace="+webhook.Namespace, strings.NewReader(payload))
+		httpRequest.Header.Set(githubEventHeader, "check_run")
+		for header, value := range test.headers {
+			httpRequest.Header.Set(header, value)
+		}
+		recorder := httptest.NewRecorder()
+		r.receiveGitHubDelivery(restful.NewRequest(httpRequest), restful.NewResponse(recorder))
+		if recorder.Code != test.wantStatus {
+			t.Errorf("%s: got status %d, want %d", test.name, recorder.Code, test.wantStatus)
+		}
+		if test.wantStatus != http.StatusAccepted {
+			if len(forwarded) != 0 {
+				t.Errorf("%s: the delivery was handed to the listener", test.name)
+			}
+			continue
+		}
+		if len(forwarded) != 1 || forwarded[0].Header.Get(githubEventHeader) != "check_run" || forwarded[0].Header.Get(githubSignature256Header) != "" {
+			t.Errorf("%s: got %d deliveries handed to the listener, want the one with only its event type", test.name, len(forwarded))
+		}
+	}
+}
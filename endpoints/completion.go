@@ -0,0 +1,93 @@
+package endpoints
+
+import (
+	"fmt"
+	"time"
+
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+/* Label on the listener's PipelineRuns saying whether their completion has been reported yet.
+Runs created before completions were reported don't have it, so they aren't all reported at once on upgrade */
+const completionReportedLabel = "completionReported"
+
+/* Completed runs are reported by this many workers, so that a slow notification endpoint doesn't hold up the others.
+When this many are waiting for a worker, claiming more waits rather than queueing without bound */
+const completionWorkers = 4
+const completionQueueSize = 100
+
+// completedRun is a finished PipelineRun this replica claimed, waiting to be reported
+type completedRun struct {
+	r           Resource
+	webhook     Webhook
+	pipelineRun v1alpha1.PipelineRun
+}
+
+/* claimCompletedRuns reports the progress of the listener's PipelineRuns, and claims those that finished since it last looked.
+Returns the claimed runs, which the caller reports */
+func (r Resource) claimCompletedRuns(namespace string) []completedRun {
+	pipelineRuns := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace)
+	list, err := pipelineRuns.List(metav1.ListOptions{LabelSelector: fmt.Sprintf("app=devops-knative,%s=false", completionReportedLabel)})
+	if err != nil {
+		r.log().Errorf("could not list PipelineRuns to report on, error: %s", err)
+		return nil
+	}
+	claimed := []completedRun{}
+	webhooks := r.readGitHubWebhook(namespace)
+	for _, pipelineRun := range list.Items {
+		if !isPipelineRunDone(pipelineRun) {
+			// Every replica may update a check run, the update is the same whichever sends it
+			if webhook, ok := webhooks[pipelineRunWebhook(pipelineRun)]; ok {
+				r.withFields("webhook", webhook.Name, "pipelineRun", pipelineRun.Name).updateCheckRun(webhook, pipelineRun)
+			}
+			continue
+		}
+		// Claim the run, if another replica got there first the update conflicts and it reports the run
+		pipelineRun.Labels[completionReportedLabel] = "true"
+		if _, err := pipelineRuns.Update(&pipelineRun); err != nil {
+			if !k8serrors.IsConflict(err) {
+				r.log().Errorf("could not mark PipelineRun %s as reported, error: %s", pipelineRun.Name, err)
+			}
+			continue
+		}
+		webhook, ok := webhooks[pipelineRunWebhook(pipelineRun)]
+		if !ok {
+			r.log().Warnf("not reporting PipelineRun %s, its webhook %s no longer exists", pipelineRun.Name, pipelineRunWebhook(pipelineRun))
+			continue
+		}
+		claimed = append(claimed, completedRun{r.withFields("webhook", webhook.Name, "pipelineRun", pipelineRun.Name), webhook, pipelineRun})
+	}
+	return claimed
+}
+
+// runCompleted reports a webhook's PipelineRun that has finished
+func (r Resource) runCompleted(webhook Webhook, pipelineRun v1alpha1.PipelineRun) {
+	status, _, _ := runStatus(pipelineRun)
+	r.log().Infof("PipelineRun %s %s", pipelineRun.Name, status)
+	r.updateCheckRun(webhook, pipelineRun)
+	r.sendNotifications(webhook, pipelineRun)
+	r.emitRunEvent(completedEventType(pipelineRun), webhook, pipelineRun)
+}
+
+// reportCompletedRuns reports the runs sent to it until the channel is closed
+func reportCompletedRuns(completed <-chan completedRun) {
+	for run := range completed {
+		run.r.runCompleted(run.webhook, run.pipelineRun)
+	}
+}
+
+// WatchPipelineRuns periodically reports the progress and completion of the listener's PipelineRuns, it does not return
+func (r Resource) WatchPipelineRuns(interval time.Duration) {
+	pipelineNs := getPipelineRunNamespace()
+	completed := make(chan completedRun, completionQueueSize)
+	for i := 0; i < completionWorkers; i++ {
+		go reportCompletedRuns(completed)
+	}
+	for range time.Tick(interval) {
+		for _, run := range r.claimCompletedRuns(pipelineNs) {
+			completed <- run
+		}
+	}
+}
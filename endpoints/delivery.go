@@ -0,0 +1,125 @@
+package endpoints
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const githubDeliveryHeader = "X-GitHub-Delivery"
+const cloudEventIDHeader = "Ce-Id"
+
+// Deliveries are recorded as ConfigMaps so that every listener replica sees them
+const deliveryConfigMapPrefix = "githubwebhook-delivery-"
+const deliveryLabel = "githubwebhook-delivery"
+const deliveryIDAnnotation = "webhooks.tekton.dev/delivery-id"
+const deliveryTimeAnnotation = "webhooks.tekton.dev/delivery-time"
+
+const defaultDeliveryTTL = 24 * time.Hour
+
+// Returns how long a delivery ID is remembered for, set through DELIVERY_TTL (e.g. 12h)
+func getDeliveryTTL() time.Duration {
+	value := os.Getenv("DELIVERY_TTL")
+	if value == "" {
+		return defaultDeliveryTTL
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Warnf("ignoring invalid DELIVERY_TTL value %s: %s", value, err)
+		return defaultDeliveryTTL
+	}
+	return ttl
+}
+
+// Delivery IDs are chosen by the sender so hash them into a valid ConfigMap name
+func deliveryConfigMapName(deliveryID string) string {
+	hash := sha1.Sum([]byte(deliveryID))
+	return deliveryConfigMapPrefix + hex.EncodeToString(hash[:])
+}
+
+func isDeliveryExpired(configMap *corev1.ConfigMap, ttl time.Duration) bool {
+	recorded, err := time.Parse(time.RFC3339, configMap.Annotations[deliveryTimeAnnotation])
+	return err != nil || time.Since(recorded) > ttl
+}
+
+/* Record a delivery ID, returning true if it was already recorded within the TTL.
+Creating the ConfigMap is atomic so only one replica wins a redelivery race */
+func (r Resource) isDuplicateDelivery(deliveryID, namespace string) (bool, error) {
+	configMapClient := r.K8sClient.CoreV1().ConfigMaps(namespace)
+	now := time.Now().UTC().Format(time.RFC3339)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      deliveryConfigMapName(deliveryID),
+			Namespace: namespace,
+			Labels:    map[string]string{"app": "devops-knative", deliveryLabel: "true"},
+			Annotations: map[string]string{
+				deliveryIDAnnotation:   deliveryID,
+				deliveryTimeAnnotation: now,
+			},
+		},
+	}
+	_, err := configMapClient.Create(configMap)
+	if err == nil {
+		return false, nil
+	}
+	if !k8serrors.IsAlreadyExists(err) {
+		return false, err
+	}
+
+	existing, err := configMapClient.Get(configMap.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	if !isDeliveryExpired(existing, getDeliveryTTL()) {
+		return true, nil
+	}
+	// Not yet cleaned up, treat it as a new delivery
+	existing.Annotations[deliveryTimeAnnotation] = now
+	if _, err := configMapClient.Update(existing); err != nil {
+		if k8serrors.IsConflict(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// forgetDelivery removes the record of a delivery, so that it is handled again if it is redelivered
+func (r Resource) forgetDelivery(deliveryID, namespace string) {
+	err := r.K8sClient.CoreV1().ConfigMaps(namespace).Delete(deliveryConfigMapName(deliveryID), &metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		r.log().Errorf("could not forget failed delivery %s, error: %s", deliveryID, err)
+	}
+}
+
+// deleteExpiredDeliveries removes delivery records older than the TTL
+func (r Resource) deleteExpiredDeliveries(namespace string) {
+	configMapClient := r.K8sClient.CoreV1().ConfigMaps(namespace)
+	list, err := configMapClient.List(metav1.ListOptions{LabelSelector: deliveryLabel + "=true"})
+	if err != nil {
+		r.log().Errorf("could not list delivery records, error: %s", err)
+		return
+	}
+	ttl := getDeliveryTTL()
+	for _, configMap := range list.Items {
+		if !isDeliveryExpired(&configMap, ttl) {
+			continue
+		}
+		if err := configMapClient.Delete(configMap.Name, &metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			r.log().Errorf("could not delete delivery record %s, error: %s", configMap.Name, err)
+		}
+	}
+}
+
+// CleanupDeliveries periodically removes expired delivery records, it does not return
+func (r Resource) CleanupDeliveries(interval time.Duration) {
+	pipelineNs := getPipelineRunNamespace()
+	for range time.Tick(interval) {
+		r.deleteExpiredDeliveries(pipelineNs)
+	}
+}
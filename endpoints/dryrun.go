@@ -0,0 +1,57 @@
+package endpoints
+
+import (
+	"net/http"
+
+	restful "github.com/emicklei/go-restful"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DryRunResponse holds the objects the listener would create for an event
+type DryRunResponse struct {
+	Webhook           string                       `json:"webhook,omitempty"`
+	PipelineResources []*v1alpha1.PipelineResource `json:"pipelineresources,omitempty"`
+	PipelineRun       *v1alpha1.PipelineRun        `json:"pipelinerun,omitempty"`
+	Error             string                       `json:"error,omitempty"`
+}
+
+// renderPipelineRun records the objects a webhook event would create in the dry run instead of creating them
+func (r Resource) renderPipelineRun(webhook Webhook, buildInformation BuildInformation, pipelineNs string) (string, error) {
+	r.dryRun.Webhook = webhook.Name
+	pipelineResources, pipelineRun, err := r.definePipelineRunObjects(webhook, buildInformation, pipelineNs, newRunSuffix())
+	if err != nil {
+		r.dryRun.Error = err.Error()
+		return "", err
+	}
+	// Typed so the rendered objects can be applied as they are
+	for _, pipelineResource := range pipelineResources {
+		pipelineResource.TypeMeta = metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "PipelineResource"}
+	}
+	pipelineRun.TypeMeta = metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "PipelineRun"}
+	r.dryRun.PipelineResources = pipelineResources
+	r.dryRun.PipelineRun = pipelineRun
+	return pipelineRun.Name, nil
+}
+
+/* Handle an event as the listener would, but respond with the PipelineResources and PipelineRun it would create
+rather than creating them. Events the webhook's filters skip get the usual skipped response */
+func (r Resource) handleDryRun(request *restful.Request, response *restful.Response) {
+	r.log().Info("Handling the event as a dry run")
+	dryRun := r
+	dryRun.dryRun = &DryRunResponse{}
+	dryRun.handleWebhook(request, response)
+
+	// Skips, ping and invalid events have been responded to already
+	if response.ContentLength() > 0 || response.StatusCode() != http.StatusOK {
+		return
+	}
+	status := http.StatusOK
+	if dryRun.dryRun.Error != "" {
+		status = http.StatusInternalServerError
+	} else if dryRun.dryRun.PipelineRun == nil {
+		status = http.StatusUnprocessableEntity
+		dryRun.dryRun.Error = "the event did not match a webhook that would create a PipelineRun"
+	}
+	response.WriteHeaderAndJson(status, dryRun.dryRun, restful.MIME_JSON)
+}
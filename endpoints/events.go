@@ -0,0 +1,71 @@
+package endpoints
+
+import (
+	"fmt"
+	"time"
+
+	eventapi "github.com/knative/eventing-sources/pkg/apis/sources/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Component named as the source of the Kubernetes Events the extension records
+const eventComponent = "webhooks-extension"
+
+// Reasons of the Events recorded over a webhook's lifetime, the retry reasons are with the retries
+const (
+	webhookCreatedReason     = "WebhookCreated"
+	webhookUpdatedReason     = "WebhookUpdated"
+	webhookDeletedReason     = "WebhookDeleted"
+	gitHubSourceFailedReason = "GitHubSourceFailed"
+	eventSkippedReason       = "EventSkipped"
+	pipelineRunCreatedReason = "PipelineRunCreated"
+	pipelineRunQueuedReason  = "PipelineRunQueued"
+)
+
+/* Record a Kubernetes Event against the webhook's GitHubSource, so that it shows in kubectl describe.
+Failing to record an Event is logged, it never fails what is being reported on */
+func (r Resource) recordWebhookEvent(webhook Webhook, eventType, reason, message string) {
+	namespace := webhook.Namespace
+	if namespace == "" {
+		namespace = getPipelineRunNamespace()
+	}
+	involved := corev1.ObjectReference{
+		APIVersion: eventapi.SchemeGroupVersion.String(),
+		Kind:       "GitHubSource",
+		Name:       webhook.Name,
+		Namespace:  namespace,
+	}
+	// The UID ties the Event to this GitHubSource rather than a later one of the same name
+	if source, err := r.EventSrcClient.SourcesV1alpha1().GitHubSources(namespace).Get(webhook.Name, metav1.GetOptions{}); err == nil {
+		involved.UID = source.UID
+		involved.ResourceVersion = source.ResourceVersion
+	}
+	now := metav1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", webhook.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: involved,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := r.K8sClient.CoreV1().Events(namespace).Create(event); err != nil {
+		r.log().Errorf("could not record event %s for webhook %s, error: %s", reason, webhook.Name, err)
+	}
+}
+
+// recordSkippedEvent records that a webhook matched an event but did not build it, dry runs aren't recorded
+func (r Resource) recordSkippedEvent(webhook Webhook, eventType, subject, reason string) {
+	if r.dryRun != nil {
+		return
+	}
+	r.recordWebhookEvent(webhook, corev1.EventTypeNormal, eventSkippedReason,
+		fmt.Sprintf("Skipped the %s event for %s: %s", eventType, subject, reason))
+}
@@ -0,0 +1,127 @@
+package endpoints
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Why filterEvent skips an event, also see the fork policy and release trigger reasons
+const noCleanupPipelineReason = "branch deleted and the webhook has no cleanup pipeline"
+const skipDirectiveReason = "found skip directive"
+const changedFilesUnknownReason = "could not get the files changed by the pull request"
+const pathsReason = "no changed files match the webhook's include and exclude paths"
+
+// Directives in a head commit message or pull request title that skip the build unless the webhook ignores them
+var defaultSkipDirectives = []string{"[skip ci]", "[ci skip]"}
+
+// filterEvent returns why the webhook's configuration says an event should not be built, or "" to build it
+func (r Resource) filterEvent(webhook Webhook, buildInformation BuildInformation) string {
+	// Releases go to the release pipeline whatever the commit message or changed files
+	if buildInformation.TAG != "" {
+		return checkReleaseTrigger(webhook, buildInformation)
+	}
+	if buildInformation.EVENTTYPE == branchDeleteEvent {
+		if webhook.CleanupPipeline == "" {
+			return noCleanupPipelineReason
+		}
+		return ""
+	}
+	if skipReason := checkForkPolicy(webhook, buildInformation); skipReason != "" {
+		return skipReason
+	}
+	if !webhook.IgnoreSkipDirectives {
+		if directive := findSkipDirective(webhook, buildInformation); directive != "" {
+			return fmt.Sprintf("%s %s", skipDirectiveReason, directive)
+		}
+	}
+	if len(webhook.IncludePaths) > 0 || len(webhook.ExcludePaths) > 0 {
+		files := buildInformation.CHANGEDFILES
+		// Pull request payloads don't list the changed files so ask the Git provider
+		if buildInformation.PULLREQUEST != "" {
+			var err error
+			files, err = r.getPullRequestFiles(webhook, buildInformation)
+			if err != nil {
+				// Building anyway would bypass the path filters, so skip and let a redelivery try again
+				r.log().Errorf("could not get the files changed by pull request %s, not building it: %s", buildInformation.PULLREQUEST, err)
+				r.delivery.failed(err)
+				return changedFilesUnknownReason
+			}
+		}
+		if len(files) > 0 && !matchesPaths(webhook, files) {
+			return pathsReason
+		}
+	}
+	return ""
+}
+
+// findSkipDirective returns the first skip directive in the commit message or pull request title, matched case insensitively
+func findSkipDirective(webhook Webhook, buildInformation BuildInformation) string {
+	text := strings.ToLower(buildInformation.COMMITMESSAGE + "\n" + buildInformation.PRTITLE)
+	directives := append(append([]string{}, defaultSkipDirectives...), webhook.SkipDirectives...)
+	for _, directive := range directives {
+		if directive != "" && strings.Contains(text, strings.ToLower(directive)) {
+			return directive
+		}
+	}
+	return ""
+}
+
+func (r Resource) getPullRequestFiles(webhook Webhook, buildInformation BuildInformation) ([]string, error) {
+	provider, err := r.getGitProvider(webhook)
+	if err != nil {
+		return nil, err
+	}
+	return provider.GetPullRequestFiles(buildInformation.REPOURL, buildInformation.PULLREQUEST)
+}
+
+// matchesPaths returns true if any of the files matches an include path (or there are none) and no exclude path
+func matchesPaths(webhook Webhook, files []string) bool {
+	for _, file := range files {
+		included := len(webhook.IncludePaths) == 0 || matchesAnyGlob(webhook.IncludePaths, file)
+		if included && !matchesAnyGlob(webhook.ExcludePaths, file) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyGlob(globs []string, file string) bool {
+	for _, glob := range globs {
+		if matchGlob(glob, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a path against a glob where ** matches across directories and * and ? match within one,
+// e.g. services/api/** matches every file under services/api
+func matchGlob(glob, file string) bool {
+	var expression strings.Builder
+	expression.WriteString("^")
+	glob = strings.TrimPrefix(glob, "/")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expression.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expression.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expression.WriteString("[^/]*")
+		case glob[i] == '?':
+			expression.WriteString("[^/]")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(glob[i])))
+		}
+	}
+	expression.WriteString("$")
+	matched, err := regexp.MatchString(expression.String(), file)
+	if err != nil {
+		logger.Warnf("ignoring invalid path glob %s: %s", glob, err)
+		return false
+	}
+	return matched
+}
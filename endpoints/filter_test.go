@@ -0,0 +1,111 @@
+package endpoints
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		glob, file string
+		want       bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/extension/main.go", true},
+		{"**/*.go", "main.golden", false},
+		{"services/api/**", "services/api/main.go", true},
+		{"services/api/**", "services/api/v1/handler.go", true},
+		{"services/api/**", "services/apiserver/main.go", false},
+		{"services/*/main.go", "services/api/main.go", true},
+		{"services/*/main.go", "services/api/v1/main.go", false},
+		{"/docs/**", "docs/index.md", true},
+		{"docs/?.md", "docs/a.md", true},
+		{"docs/?.md", "docs/ab.md", false},
+		{"docs/?.md", "docs//.md", false},
+		{"README.md", "README.md", true},
+		{"README.md", "README-md", false},
+		{"a+b(c).txt", "a+b(c).txt", true},
+		{"[abc].txt", "a.txt", false},
+		{"", "", true},
+		{"", "file", false},
+	}
+	for _, test := range tests {
+		if got := matchGlob(test.glob, test.file); got != test.want {
+			t.Errorf("matchGlob(%q, %q) = %t, want %t", test.glob, test.file, got, test.want)
+		}
+	}
+}
+
+func TestMatchesPaths(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude []string
+		files            []string
+		want             bool
+	}{
+		{"no filters", nil, nil, []string{"main.go"}, true},
+		{"included", []string{"src/**"}, nil, []string{"docs/a.md", "src/main.go"}, true},
+		{"not included", []string{"src/**"}, nil, []string{"docs/a.md"}, false},
+		{"excluded", nil, []string{"docs/**"}, []string{"docs/a.md"}, false},
+		{"one not excluded", nil, []string{"docs/**"}, []string{"docs/a.md", "main.go"}, true},
+		{"included then excluded", []string{"src/**"}, []string{"**/*_test.go"}, []string{"src/main_test.go"}, false},
+		{"no files", []string{"src/**"}, nil, nil, false},
+	}
+	for _, test := range tests {
+		webhook := Webhook{IncludePaths: test.include, ExcludePaths: test.exclude}
+		if got := matchesPaths(webhook, test.files); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFindSkipDirective(t *testing.T) {
+	tests := []struct {
+		message, title string
+		custom         []string
+		want           string
+	}{
+		{"fix a bug", "", nil, ""},
+		{"fix a bug [skip ci]", "", nil, "[skip ci]"},
+		{"docs [CI SKIP]", "", nil, "[ci skip]"},
+		{"", "WIP: [Skip CI] tidy up", nil, "[skip ci]"},
+		{"docs only [no build]", "", []string{"[no build]"}, "[no build]"},
+		{"anything", "", []string{""}, ""},
+	}
+	for _, test := range tests {
+		webhook := Webhook{SkipDirectives: test.custom}
+		buildInformation := BuildInformation{COMMITMESSAGE: test.message, PRTITLE: test.title}
+		if got := findSkipDirective(webhook, buildInformation); got != test.want {
+			t.Errorf("findSkipDirective(%q, %q) = %q, want %q", test.message, test.title, got, test.want)
+		}
+	}
+}
+
+func TestFilterEventPullRequestPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		filesErr error
+		skipped  bool
+	}{
+		{"matching file", []string{"src/main.go"}, nil, false},
+		{"no matching file", []string{"docs/index.md"}, nil, true},
+		// The path filters can't be checked, so the pull request must not be built
+		{"lookup failed", nil, errors.New("API rate limit exceeded"), true},
+	}
+	for _, test := range tests {
+		r, webhook := newFakeProviderResource(&fakeGitProvider{files: test.files, filesErr: test.filesErr})
+		webhook.IncludePaths = []string{"src/**"}
+		buildInformation := BuildInformation{
+			EVENTTYPE:   "pull_request",
+			REPOURL:     webhook.GitRepositoryURL,
+			PULLREQUEST: "1",
+		}
+		reason := r.filterEvent(webhook, buildInformation)
+		if skipped := reason != ""; skipped != test.skipped {
+			t.Errorf("%s: got skip reason %q, want skipped %t", test.name, reason, test.skipped)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package endpoints
+
+import "fmt"
+
+// Values for a webhook's fork policy, deciding what happens to pull requests from forks
+const forkPolicyBuild = "build"
+const forkPolicySkip = "skip"
+const forkPolicyApprove = "approve"
+const forkPolicyUntrusted = "untrusted"
+
+// Why checkForkPolicy skips a pull request
+const forkSkippedReason = "pull requests from forks are not built"
+const forkApprovalReason = "pull request from a fork needs approval"
+
+// A label trusted users put on a pull request from a fork to approve building it
+const okToTestLabel = "ok-to-test"
+
+// isApproved returns true if a trusted user approved building the pull request, by label or ChatOps command
+func isApproved(buildInformation BuildInformation) bool {
+	if buildInformation.APPROVED {
+		return true
+	}
+	for _, label := range buildInformation.PRLABELS {
+		if label == okToTestLabel {
+			return true
+		}
+	}
+	return false
+}
+
+// isUntrusted returns true for pull requests from forks that nobody approved, which the webhook's fork policy
+// says to build without its service account and secrets
+func isUntrusted(webhook Webhook, buildInformation BuildInformation) bool {
+	return webhook.ForkPolicy == forkPolicyUntrusted && buildInformation.FORK && !isApproved(buildInformation)
+}
+
+// checkForkPolicy returns why a pull request from a fork should not be built under the webhook's fork policy, or ""
+func checkForkPolicy(webhook Webhook, buildInformation BuildInformation) string {
+	if !buildInformation.FORK {
+		return ""
+	}
+	switch webhook.ForkPolicy {
+	case forkPolicySkip:
+		return forkSkippedReason
+	case forkPolicyApprove:
+		if !isApproved(buildInformation) {
+			return fmt.Sprintf("%s: add the %s label or comment %s", forkApprovalReason, okToTestLabel, okToTestCommand)
+		}
+	}
+	return ""
+}
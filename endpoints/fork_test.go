@@ -0,0 +1,40 @@
+package endpoints
+
+import (
+	"testing"
+)
+
+func TestCheckForkPolicy(t *testing.T) {
+	fork := BuildInformation{FORK: true}
+	labelled := BuildInformation{FORK: true, PRLABELS: []string{"bug", okToTestLabel}}
+	approved := BuildInformation{FORK: true, APPROVED: true}
+	tests := []struct {
+		name             string
+		policy           string
+		buildInformation BuildInformation
+		built            bool
+		untrusted        bool
+	}{
+		{"not a fork", forkPolicySkip, BuildInformation{}, true, false},
+		{"default policy", "", fork, true, false},
+		{"build policy", forkPolicyBuild, fork, true, false},
+		{"skip policy", forkPolicySkip, fork, false, false},
+		{"skip policy approved", forkPolicySkip, approved, false, false},
+		{"approve policy", forkPolicyApprove, fork, false, false},
+		{"approve policy labelled", forkPolicyApprove, labelled, true, false},
+		{"approve policy approved", forkPolicyApprove, approved, true, false},
+		{"untrusted policy", forkPolicyUntrusted, fork, true, true},
+		{"untrusted policy labelled", forkPolicyUntrusted, labelled, true, false},
+		{"untrusted policy not a fork", forkPolicyUntrusted, BuildInformation{}, true, false},
+	}
+	for _, test := range tests {
+		webhook := Webhook{ForkPolicy: test.policy}
+		reason := checkForkPolicy(webhook, test.buildInformation)
+		if built := reason == ""; built != test.built {
+			t.Errorf("%s: got reason %q, want built %t", test.name, reason, test.built)
+		}
+		if untrusted := isUntrusted(webhook, test.buildInformation); untrusted != test.untrusted {
+			t.Errorf("%s: got untrusted %t, want %t", test.name, untrusted, test.untrusted)
+		}
+	}
+}
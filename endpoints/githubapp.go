@@ -0,0 +1,151 @@
+package endpoints
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keys of a webhook's secret holding the ID and private key of a GitHub App installed on the repository.
+// With them the listener calls the GitHub API as the App's installation, which check runs need, instead of with accessToken
+const githubAppIDKey = "appID"
+const githubAppPrivateKeyKey = "privateKey"
+
+// Installation tokens expire after an hour, a new one is minted once the one in use has less than this left
+const installationTokenMargin = 5 * time.Minute
+
+// GitHub Enterprise versions of the time only answer the Apps API when asked for its preview
+const githubAppsMediaType = "application/vnd.github.machine-man-preview+json"
+
+// installationToken is an access token of a GitHub App's installation and when it expires
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Installation tokens in use, by API, App and repository
+var (
+	installationTokens     = make(map[string]installationToken)
+	installationTokensLock sync.Mutex
+)
+
+var githubAppClient = &http.Client{Timeout: 30 * time.Second}
+
+// parseGitHubAppKey parses the PEM private key GitHub generates for an App
+func parseGitHubAppKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("the private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// githubAppJWT returns the JSON Web Token a GitHub App authenticates as itself with. GitHub takes tokens valid for
+// at most ten minutes, it is issued a minute early to allow for clock drift
+func githubAppJWT(appID string, key *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// sendAsGitHubApp sends a request to the Apps API authenticated as the App, and decodes the JSON response into result
+func sendAsGitHubApp(method, url, jwt string, result interface{}) error {
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", githubAppsMediaType)
+	request.Header.Set("Authorization", "Bearer "+jwt)
+	response, err := githubAppClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		return fmt.Errorf("%s %s returned %s", method, url, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// getInstallationToken returns an access token of the GitHub App's installation on the repository, minting a new one
+// when the last one is about to expire. The API is the repository server's, or GITHUB_API_URL when set
+func getInstallationToken(appID string, privateKey []byte, repoURL string) (string, error) {
+	apiURL, ownerAndRepo, err := getGitHubAPIValues(repoURL)
+	if err != nil {
+		return "", err
+	}
+	if override := os.Getenv("GITHUB_API_URL"); override != "" {
+		apiURL = strings.TrimSuffix(override, "/")
+	}
+	key := strings.Join([]string{apiURL, appID, ownerAndRepo}, "\x00")
+	installationTokensLock.Lock()
+	cached, ok := installationTokens[key]
+	installationTokensLock.Unlock()
+	if ok && time.Now().Add(installationTokenMargin).Before(cached.ExpiresAt) {
+		return cached.Token, nil
+	}
+
+	signingKey, err := parseGitHubAppKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	jwt, err := githubAppJWT(appID, signingKey, time.Now())
+	if err != nil {
+		return "", err
+	}
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	if err := sendAsGitHubApp(http.MethodGet, fmt.Sprintf("%s/repos/%s/installation", apiURL, ownerAndRepo), jwt, &installation); err != nil {
+		return "", fmt.Errorf("could not find the installation of GitHub App %s on %s: %s", appID, ownerAndRepo, err)
+	}
+	token := installationToken{}
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", apiURL, installation.ID)
+	if err := sendAsGitHubApp(http.MethodPost, url, jwt, &token); err != nil {
+		return "", fmt.Errorf("could not create an access token for installation %d of GitHub App %s: %s", installation.ID, appID, err)
+	}
+	installationTokensLock.Lock()
+	installationTokens[key] = token
+	installationTokensLock.Unlock()
+	logger.Infof("Created an access token for installation %d of GitHub App %s, expiring at %s", installation.ID, appID, token.ExpiresAt)
+	return token.Token, nil
+}
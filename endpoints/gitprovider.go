@@ -0,0 +1,345 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GitProvider looks up information from a Git provider's API that event payloads don't carry
+type GitProvider interface {
+	// GetPullRequestFiles returns the paths of the files changed by a pull request
+	GetPullRequestFiles(repoURL, number string) ([]string, error)
+	// GetPullRequest returns the current head of a pull request
+	GetPullRequest(repoURL, number string) (PullRequest, error)
+	// GetPermission returns a user's permission on the repository: admin, write, read or none
+	GetPermission(repoURL, user string) (string, error)
+	// GetCommit resolves a branch, tag or commit to a commit
+	GetCommit(repoURL, ref string) (Commit, error)
+	// CreateCheckRun creates a check run on a commit, returning its ID
+	CreateCheckRun(repoURL string, checkRun CheckRun) (int64, error)
+	// UpdateCheckRun updates the status and output of a check run
+	UpdateCheckRun(repoURL string, id int64, checkRun CheckRun) error
+}
+
+// Commit is the commit information GitProviders look up
+type Commit struct {
+	SHA     string
+	Message string
+}
+
+// PullRequest is the pull request information GitProviders look up
+type PullRequest struct {
+	Number  string
+	Title   string
+	HeadSha string
+	HeadRef string
+	Author  string
+	Fork    bool
+	Labels  []string
+}
+
+// CheckRun is the status of a pipeline on a commit as GitProviders report it, with its output in Markdown
+type CheckRun struct {
+	Name        string
+	HeadSHA     string
+	DetailsURL  string
+	ExternalID  string
+	Status      string
+	Conclusion  string
+	StartedAt   time.Time
+	CompletedAt time.Time
+	Title       string
+	Summary     string
+	Text        string
+}
+
+// GitProviderFactory returns the GitProvider for a webhook, tests can supply one returning a fake
+type GitProviderFactory func(webhook Webhook, accessToken string) GitProvider
+
+// The GitHub API returns at most 100 items per page
+const githubPageSize = 100
+
+// The checks API was a preview on GitHub Enterprise versions of the time, it has to be asked for
+const githubChecksMediaType = "application/vnd.github.antiope-preview+json"
+
+// GitHubProvider is a GitProvider for github.com and GitHub Enterprise.
+// APIURL, when set, is used instead of the API of the repository's server, e.g. for a fake GitHub API
+type GitHubProvider struct {
+	AccessToken string
+	Client      *http.Client
+	APIURL      string
+}
+
+// NewGitHubProvider returns a GitHubProvider authenticating with the given access token, using the API at GITHUB_API_URL if set
+func NewGitHubProvider(webhook Webhook, accessToken string) GitProvider {
+	return GitHubProvider{AccessToken: accessToken, Client: &http.Client{Timeout: 30 * time.Second}, APIURL: os.Getenv("GITHUB_API_URL")}
+}
+
+// getGitProvider returns the GitProvider for a webhook using the access token from its secret,
+// or an installation token of the GitHub App the secret has the ID and private key of
+func (r Resource) getGitProvider(webhook Webhook) (GitProvider, error) {
+	namespace := webhook.Namespace
+	if namespace == "" {
+		namespace = getPipelineRunNamespace()
+	}
+	secret, err := r.K8sClient.CoreV1().Secrets(namespace).Get(webhook.AccessTokenRef, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	accessToken := strings.TrimSpace(string(secret.Data["accessToken"]))
+	if appID := strings.TrimSpace(string(secret.Data[githubAppIDKey])); appID != "" {
+		if accessToken, err = getInstallationToken(appID, secret.Data[githubAppPrivateKeyKey], webhook.GitRepositoryURL); err != nil {
+			return nil, err
+		}
+	}
+	return r.GitProvider(webhook, accessToken), nil
+}
+
+// Returns the API base URL and owner/repo for a repository URL, e.g. https://api.github.com and tektoncd/pipeline
+func getGitHubAPIValues(repoURL string) (apiURL, ownerAndRepo string, err error) {
+	gitServer, gitOrg, gitRepo, err := getGitValues(repoURL)
+	if err != nil {
+		return "", "", err
+	}
+	apiURL = fmt.Sprintf("https://%s/api/v3", gitServer)
+	if gitServer == "github.com" {
+		apiURL = "https://api.github.com"
+	}
+	return apiURL, gitOrg + "/" + strings.TrimSuffix(gitRepo, ".git"), nil
+}
+
+// Returns the API base URL and owner/repo for a repository URL, using the provider's API URL when it has one
+func (p GitHubProvider) apiValues(repoURL string) (apiURL, ownerAndRepo string, err error) {
+	apiURL, ownerAndRepo, err = getGitHubAPIValues(repoURL)
+	if err == nil && p.APIURL != "" {
+		apiURL = strings.TrimSuffix(p.APIURL, "/")
+	}
+	return apiURL, ownerAndRepo, err
+}
+
+// send sends an authenticated request to the API with an optional JSON body, and decodes the JSON response into result if given
+func (p GitHubProvider) send(method, url, mediaType string, body, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	request, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", mediaType)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if p.AccessToken != "" {
+		request.Header.Set("Authorization", "token "+p.AccessToken)
+	}
+	response, err := p.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		return fmt.Errorf("%s %s returned %s", method, url, response.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// get sends an authenticated GET to the API and decodes the JSON response into result
+func (p GitHubProvider) get(url string, result interface{}) error {
+	return p.send(http.MethodGet, url, "application/vnd.github.v3+json", nil, result)
+}
+
+// GetPullRequestFiles returns the paths of the files changed by a pull request, including the previous path of renamed files
+func (p GitHubProvider) GetPullRequestFiles(repoURL, number string) ([]string, error) {
+	apiURL, ownerAndRepo, err := p.apiValues(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/repos/%s/pulls/%s/files?per_page=%d&page=%d", apiURL, ownerAndRepo, number, githubPageSize, page)
+		var pageFiles []struct {
+			Filename         string `json:"filename"`
+			PreviousFilename string `json:"previous_filename"`
+		}
+		if err := p.get(url, &pageFiles); err != nil {
+			return nil, err
+		}
+		for _, file := range pageFiles {
+			files = append(files, file.Filename)
+			if file.PreviousFilename != "" {
+				files = append(files, file.PreviousFilename)
+			}
+		}
+		if len(pageFiles) < githubPageSize {
+			break
+		}
+	}
+	logger.Infof("Pull request %s of %s changed %d file(s)", number, ownerAndRepo, len(files))
+	return files, nil
+}
+
+// GetPullRequest returns the current head of a pull request
+func (p GitHubProvider) GetPullRequest(repoURL, number string) (PullRequest, error) {
+	apiURL, ownerAndRepo, err := p.apiValues(repoURL)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	var pull struct {
+		Title string `json:"title"`
+		User  struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Labels []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+		Head struct {
+			Ref  string `json:"ref"`
+			Sha  string `json:"sha"`
+			Repo struct {
+				FullName string `json:"full_name"`
+			} `json:"repo"`
+		} `json:"head"`
+		Base struct {
+			Repo struct {
+				FullName string `json:"full_name"`
+			} `json:"repo"`
+		} `json:"base"`
+	}
+	if err := p.get(fmt.Sprintf("%s/repos/%s/pulls/%s", apiURL, ownerAndRepo, number), &pull); err != nil {
+		return PullRequest{}, err
+	}
+	labels := []string{}
+	for _, label := range pull.Labels {
+		labels = append(labels, label.Name)
+	}
+	return PullRequest{
+		Number:  number,
+		Title:   pull.Title,
+		HeadSha: pull.Head.Sha,
+		HeadRef: pull.Head.Ref,
+		Author:  pull.User.Login,
+		Fork:    pull.Head.Repo.FullName != pull.Base.Repo.FullName,
+		Labels:  labels,
+	}, nil
+}
+
+// GetPermission returns a user's permission on the repository: admin, write, read or none
+func (p GitHubProvider) GetPermission(repoURL, user string) (string, error) {
+	apiURL, ownerAndRepo, err := p.apiValues(repoURL)
+	if err != nil {
+		return "", err
+	}
+	var permission struct {
+		Permission string `json:"permission"`
+	}
+	if err := p.get(fmt.Sprintf("%s/repos/%s/collaborators/%s/permission", apiURL, ownerAndRepo, user), &permission); err != nil {
+		return "", err
+	}
+	return permission.Permission, nil
+}
+
+// GetCommit resolves a branch, tag or commit to a commit
+func (p GitHubProvider) GetCommit(repoURL, ref string) (Commit, error) {
+	apiURL, ownerAndRepo, err := p.apiValues(repoURL)
+	if err != nil {
+		return Commit{}, err
+	}
+	var commit struct {
+		SHA    string `json:"sha"`
+		Commit struct {
+			Message string `json:"message"`
+		} `json:"commit"`
+	}
+	if err := p.get(fmt.Sprintf("%s/repos/%s/commits/%s", apiURL, ownerAndRepo, url.PathEscape(ref)), &commit); err != nil {
+		return Commit{}, err
+	}
+	return Commit{SHA: commit.SHA, Message: commit.Commit.Message}, nil
+}
+
+// githubCheckRun is a check run as the GitHub checks API takes it
+type githubCheckRun struct {
+	Name        string                `json:"name,omitempty"`
+	HeadSHA     string                `json:"head_sha,omitempty"`
+	DetailsURL  string                `json:"details_url,omitempty"`
+	ExternalID  string                `json:"external_id,omitempty"`
+	Status      string                `json:"status,omitempty"`
+	Conclusion  string                `json:"conclusion,omitempty"`
+	StartedAt   string                `json:"started_at,omitempty"`
+	CompletedAt string                `json:"completed_at,omitempty"`
+	Output      *githubCheckRunOutput `json:"output,omitempty"`
+}
+
+type githubCheckRunOutput struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+	Text    string `json:"text,omitempty"`
+}
+
+// Returns a time as the GitHub API takes it, or "" for the zero time
+func githubTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func newGitHubCheckRun(checkRun CheckRun) githubCheckRun {
+	result := githubCheckRun{
+		Name:        checkRun.Name,
+		HeadSHA:     checkRun.HeadSHA,
+		DetailsURL:  checkRun.DetailsURL,
+		ExternalID:  checkRun.ExternalID,
+		Status:      checkRun.Status,
+		Conclusion:  checkRun.Conclusion,
+		StartedAt:   githubTime(checkRun.StartedAt),
+		CompletedAt: githubTime(checkRun.CompletedAt),
+	}
+	if checkRun.Title != "" {
+		result.Output = &githubCheckRunOutput{Title: checkRun.Title, Summary: checkRun.Summary, Text: checkRun.Text}
+	}
+	return result
+}
+
+// CreateCheckRun creates a check run on a commit, the access token must be a GitHub App's as only apps can create check runs
+func (p GitHubProvider) CreateCheckRun(repoURL string, checkRun CheckRun) (int64, error) {
+	apiURL, ownerAndRepo, err := p.apiValues(repoURL)
+	if err != nil {
+		return 0, err
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	url := fmt.Sprintf("%s/repos/%s/check-runs", apiURL, ownerAndRepo)
+	if err := p.send(http.MethodPost, url, githubChecksMediaType, newGitHubCheckRun(checkRun), &created); err != nil {
+		return 0, err
+	}
+	return created.ID, nil
+}
+
+// UpdateCheckRun updates a check run, the name and commit of a check run can't be changed so they are left out
+func (p GitHubProvider) UpdateCheckRun(repoURL string, id int64, checkRun CheckRun) error {
+	apiURL, ownerAndRepo, err := p.apiValues(repoURL)
+	if err != nil {
+		return err
+	}
+	update := newGitHubCheckRun(checkRun)
+	update.HeadSHA = ""
+	url := fmt.Sprintf("%s/repos/%s/check-runs/%d", apiURL, ownerAndRepo, id)
+	return p.send(http.MethodPatch, url, githubChecksMediaType, update, nil)
+}
@@ -0,0 +1,139 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeGitProvider is a GitProvider answering from its fields, recording the check runs it is sent
+type fakeGitProvider struct {
+	files       []string
+	filesErr    error
+	pullRequest PullRequest
+	permission  string
+	commit      Commit
+	checkRuns   map[int64]CheckRun
+}
+
+func (p *fakeGitProvider) GetPullRequestFiles(repoURL, number string) ([]string, error) {
+	return p.files, p.filesErr
+}
+
+func (p *fakeGitProvider) GetPullRequest(repoURL, number string) (PullRequest, error) {
+	return p.pullRequest, nil
+}
+
+func (p *fakeGitProvider) GetPermission(repoURL, user string) (string, error) {
+	return p.permission, nil
+}
+
+func (p *fakeGitProvider) GetCommit(repoURL, ref string) (Commit, error) {
+	return p.commit, nil
+}
+
+func (p *fakeGitProvider) CreateCheckRun(repoURL string, checkRun CheckRun) (int64, error) {
+	if p.checkRuns == nil {
+		p.checkRuns = map[int64]CheckRun{}
+	}
+	id := int64(len(p.checkRuns) + 1)
+	p.checkRuns[id] = checkRun
+	return id, nil
+}
+
+func (p *fakeGitProvider) UpdateCheckRun(repoURL string, id int64, checkRun CheckRun) error {
+	if _, ok := p.checkRuns[id]; !ok {
+		return fmt.Errorf("no check run %d", id)
+	}
+	p.checkRuns[id] = checkRun
+	return nil
+}
+
+// newFakeProviderResource returns a Resource whose webhooks use the given GitProvider
+func newFakeProviderResource(provider GitProvider) (Resource, Webhook) {
+	webhook := Webhook{
+		Name:             "test-webhook",
+		Namespace:        "default",
+		GitRepositoryURL: "https://github.com/owner/repo",
+		AccessTokenRef:   "test-token",
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: webhook.AccessTokenRef, Namespace: webhook.Namespace},
+		Data:       map[string][]byte{"accessToken": []byte("token")},
+	}
+	r := Resource{
+		K8sClient:   k8sfake.NewSimpleClientset(secret),
+		GitProvider: func(Webhook, string) GitProvider { return provider },
+	}
+	return r, webhook
+}
+
+func TestGetGitHubAPIValues(t *testing.T) {
+	tests := []struct {
+		repoURL, apiURL, ownerAndRepo string
+	}{
+		{"https://github.com/owner/repo", "https://api.github.com", "owner/repo"},
+		{"https://github.com/owner/repo.git", "https://api.github.com", "owner/repo"},
+		{"https://github.example.com/owner/repo", "https://github.example.com/api/v3", "owner/repo"},
+	}
+	for _, test := range tests {
+		apiURL, ownerAndRepo, err := getGitHubAPIValues(test.repoURL)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.repoURL, err)
+			continue
+		}
+		if apiURL != test.apiURL || ownerAndRepo != test.ownerAndRepo {
+			t.Errorf("%s: got %s %s, want %s %s", test.repoURL, apiURL, ownerAndRepo, test.apiURL, test.ownerAndRepo)
+		}
+	}
+}
+
+func TestGitHubProviderGetPullRequestFiles(t *testing.T) {
+	// A full first page means there may be more, the second page ends the list
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/repos/owner/repo/pulls/5/files" {
+			http.NotFound(w, request)
+			return
+		}
+		if request.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		files := []map[string]string{}
+		switch request.URL.Query().Get("page") {
+		case "1":
+			for i := 0; i < githubPageSize; i++ {
+				files = append(files, map[string]string{"filename": "file" + strconv.Itoa(i)})
+			}
+		case "2":
+			files = append(files, map[string]string{"filename": "new.go", "previous_filename": "old.go"})
+		}
+		json.NewEncoder(w).Encode(files)
+	}))
+	defer server.Close()
+
+	provider := GitHubProvider{AccessToken: "secret", Client: server.Client(), APIURL: server.URL}
+	files, err := provider.GetPullRequestFiles("https://github.com/owner/repo", "5")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(files) != githubPageSize+2 {
+		t.Fatalf("got %d files, want %d", len(files), githubPageSize+2)
+	}
+	if got := files[githubPageSize:]; !reflect.DeepEqual(got, []string{"new.go", "old.go"}) {
+		t.Errorf("got renamed files %v, want both names", got)
+	}
+
+	provider.AccessToken = "wrong"
+	if _, err := provider.GetPullRequestFiles("https://github.com/owner/repo", "5"); err == nil {
+		t.Error("expected an error when the API rejects the token")
+	}
+}
@@ -0,0 +1,314 @@
+package endpoints
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Each received event is recorded as a ConfigMap, labelled with the webhook it matched
+const historyConfigMapPrefix = "githubwebhook-history-"
+const historyLabel = "githubwebhook-history"
+const historyKey = "delivery"
+
+// Payloads are cut at this size to keep the ConfigMaps well under the 1MB object size limit
+const maxHistoryPayload = 256 * 1024
+
+const defaultHistoryLimit = 50
+
+// replayHeader names the delivery an event replays
+const replayHeader = "X-Webhook-Replay-Of"
+
+var listenerClient = &http.Client{Timeout: 30 * time.Second}
+
+// Delivery records an event the listener received and what it did with it
+type Delivery struct {
+	ID               string            `json:"id"`
+	Time             string            `json:"time"`
+	Event            string            `json:"event,omitempty"`
+	Headers          map[string]string `json:"headers"`
+	Payload          string            `json:"payload"`
+	PayloadTruncated bool              `json:"payloadtruncated,omitempty"`
+	ReplayOf         string            `json:"replayof,omitempty"`
+	Webhook          string            `json:"webhook,omitempty"`
+	Status           int               `json:"status"`
+	Skipped          bool              `json:"skipped,omitempty"`
+	Reason           string            `json:"reason,omitempty"`
+	Queued           bool              `json:"queued,omitempty"`
+	PipelineRun      string            `json:"pipelinerun,omitempty"`
+	Error            string            `json:"error,omitempty"`
+}
+
+// The recording methods do nothing on a nil Delivery, so callers needn't check whether the event is being recorded
+
+func (d *Delivery) matched(webhook Webhook) {
+	if d != nil {
+		d.Webhook = webhook.Name
+	}
+}
+
+func (d *Delivery) skipped(reason string) {
+	if d != nil {
+		d.Skipped = true
+		d.Reason = reason
+	}
+}
+
+// started records the created PipelineRun, or that it was queued when there is none
+func (d *Delivery) started(pipelineRunName string) {
+	if d != nil {
+		d.PipelineRun = pipelineRunName
+		d.Queued = pipelineRunName == ""
+	}
+}
+
+func (d *Delivery) failed(err error) {
+	if d != nil {
+		d.Error = err.Error()
+	}
+}
+
+func (d *Delivery) hasFailed() bool {
+	return d != nil && d.Error != ""
+}
+
+// Returns how many deliveries are kept per webhook, set through DELIVERY_HISTORY_LIMIT
+func getHistoryLimit() int {
+	value := os.Getenv("DELIVERY_HISTORY_LIMIT")
+	if value == "" {
+		return defaultHistoryLimit
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		logger.Warnf("ignoring invalid DELIVERY_HISTORY_LIMIT value %s", value)
+		return defaultHistoryLimit
+	}
+	return limit
+}
+
+func historyConfigMapName(id string) string {
+	hash := sha1.Sum([]byte(id))
+	return historyConfigMapPrefix + hex.EncodeToString(hash[:])
+}
+
+// handleRecordedWebhook handles an event, recording it and what was done with it in the webhook's event history
+func (r Resource) handleRecordedWebhook(request *restful.Request, response *restful.Response) {
+	payload, err := ioutil.ReadAll(io.LimitReader(request.Request.Body, maxHistoryPayload+1))
+	if err != nil {
+		r.log().Errorf("could not read the event, error: %s", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	// Hand the whole event on, including anything past the recorded part
+	request.Request.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(payload), request.Request.Body))
+
+	delivery := &Delivery{
+		ID:       getDeliveryID(request),
+		Time:     time.Now().UTC().Format(time.RFC3339),
+		Event:    strings.Replace(getEventType(request), "\"", "", -1),
+		Headers:  make(map[string]string),
+		ReplayOf: request.HeaderParameter(replayHeader),
+	}
+	if delivery.ID == "" {
+		delivery.ID = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	for name, values := range request.Request.Header {
+		if !sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			delivery.Headers[name] = strings.Join(values, ",")
+		}
+	}
+	if len(payload) > maxHistoryPayload {
+		payload = payload[0:maxHistoryPayload]
+		delivery.PayloadTruncated = true
+	}
+	delivery.Payload = string(payload)
+
+	recorded := r
+	recorded.delivery = delivery
+	recorded.handleWebhook(request, response)
+	// Counted once handled, by the webhook it matched rather than the repository the sender named
+	recordEvent(delivery.Event, delivery.Webhook)
+
+	delivery.Status = response.StatusCode()
+	if err := r.recordDelivery(delivery, getPipelineRunNamespace()); err != nil {
+		r.log().Errorf("could not record delivery %s, error: %s", delivery.ID, err)
+	}
+}
+
+// recordDelivery stores a delivery, then drops the oldest deliveries of its webhook past the history limit
+func (r Resource) recordDelivery(delivery *Delivery, namespace string) error {
+	buf, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      historyConfigMapName(delivery.ID),
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app":        "devops-knative",
+				historyLabel: "true",
+				webhookLabel: labelValue(delivery.Webhook),
+			},
+		},
+		BinaryData: map[string][]byte{historyKey: buf},
+	}
+	configMapClient := r.K8sClient.CoreV1().ConfigMaps(namespace)
+	if _, err := configMapClient.Create(configMap); err != nil {
+		// A redelivery, keep the record of the delivery that was handled
+		if k8serrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+
+	list, err := configMapClient.List(metav1.ListOptions{LabelSelector: historySelector(delivery.Webhook)})
+	if err != nil {
+		return err
+	}
+	limit := getHistoryLimit()
+	if len(list.Items) <= limit {
+		return nil
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[j].CreationTimestamp.Before(&list.Items[i].CreationTimestamp)
+	})
+	for _, old := range list.Items[limit:] {
+		if err := configMapClient.Delete(old.Name, &metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			r.log().Errorf("could not delete delivery record %s, error: %s", old.Name, err)
+		}
+	}
+	return nil
+}
+
+func historySelector(webhookName string) string {
+	return fmt.Sprintf("%s=true,%s=%s", historyLabel, webhookLabel, labelValue(webhookName))
+}
+
+// readDeliveries returns a webhook's recorded deliveries, most recent first
+func (r Resource) readDeliveries(webhookName, namespace string) ([]Delivery, error) {
+	list, err := r.K8sClient.CoreV1().ConfigMaps(namespace).List(metav1.ListOptions{LabelSelector: historySelector(webhookName)})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []Delivery{}
+	for _, configMap := range list.Items {
+		delivery := Delivery{}
+		if err := json.Unmarshal(configMap.BinaryData[historyKey], &delivery); err != nil {
+			r.log().Warnf("ignoring unreadable delivery record %s, error: %s", configMap.Name, err)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].Time > deliveries[j].Time
+	})
+	return deliveries, nil
+}
+
+func (r Resource) getDeliveries(request *restful.Request, response *restful.Response) {
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	deliveries, err := r.readDeliveries(request.PathParameter("name"), namespace)
+	if err != nil {
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	response.WriteEntity(deliveries)
+}
+
+/* Send a recorded delivery through the listener again and respond as the listener did.
+The replay is recorded as a new delivery as it may well be handled differently */
+func (r Resource) replayDelivery(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	id := request.PathParameter("id")
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	configMap, err := r.K8sClient.CoreV1().ConfigMaps(namespace).Get(historyConfigMapName(id), metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			RespondErrorMessage(response, fmt.Sprintf("no delivery %s for webhook %s", id, name), http.StatusNotFound)
+			return
+		}
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	delivery := Delivery{}
+	if err := json.Unmarshal(configMap.BinaryData[historyKey], &delivery); err != nil {
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	if delivery.Webhook != name {
+		RespondErrorMessage(response, fmt.Sprintf("no delivery %s for webhook %s", id, name), http.StatusNotFound)
+		return
+	}
+	if delivery.PayloadTruncated {
+		RespondErrorMessage(response, fmt.Sprintf("delivery %s was too large to record in full and can't be replayed", id), http.StatusConflict)
+		return
+	}
+
+	// The event goes through the listener service like the original did, with its service account and checks
+	replay, err := http.NewRequest(http.MethodPost, getListenerURL(namespace), strings.NewReader(delivery.Payload))
+	if err != nil {
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	for header, value := range delivery.Headers {
+		replay.Header.Set(header, value)
+	}
+	// A new delivery ID, or the replay would be skipped as a duplicate
+	replay.Header.Del(githubDeliveryHeader)
+	replay.Header.Set(cloudEventIDHeader, fmt.Sprintf("%s-replay-%d", id, time.Now().UnixNano()))
+	replay.Header.Set(replayHeader, id)
+	r.log().Infof("Replaying delivery %s for webhook %s to %s", id, name, replay.URL)
+
+	if err := forwardToListener(replay, response); err != nil {
+		r.log().Errorf("could not replay delivery %s to the listener, error: %s", id, err)
+		RespondError(response, err, http.StatusBadGateway)
+	}
+}
+
+// forwardToListener sends an event to the listener and responds as the listener did
+func forwardToListener(event *http.Request, response *restful.Response) error {
+	listenerResponse, err := listenerClient.Do(event)
+	if err != nil {
+		return err
+	}
+	defer listenerResponse.Body.Close()
+	if contentType := listenerResponse.Header.Get("Content-Type"); contentType != "" {
+		response.AddHeader("Content-Type", contentType)
+	}
+	response.WriteHeader(listenerResponse.StatusCode)
+	io.Copy(response, listenerResponse.Body)
+	return nil
+}
+
+// Returns where replayed and verified events are sent, set through LISTENER_URL or else the listener service in the namespace
+func getListenerURL(namespace string) string {
+	if listenerURL := os.Getenv("LISTENER_URL"); listenerURL != "" {
+		return listenerURL
+	}
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local", listenerServiceName, namespace)
+}
@@ -0,0 +1,166 @@
+package endpoints
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+)
+
+// Labels the listener adds to PipelineRuns, so that e.g. all runs for a pull request or by a user can be selected
+const eventTypeLabel = "eventType"
+const gitTagLabel = "gitTag"
+const gitCommitLabel = "gitCommit"
+const gitSenderLabel = "gitSender"
+
+// Annotations carry the values in full, labels only hold what fits in a label value
+const webhookAnnotation = "webhooks.tekton.dev/webhook"
+const eventTypeAnnotation = "webhooks.tekton.dev/event-type"
+const branchAnnotation = "webhooks.tekton.dev/branch"
+const tagAnnotation = "webhooks.tekton.dev/tag"
+const pullRequestAnnotation = "webhooks.tekton.dev/pull-request"
+const commitAnnotation = "webhooks.tekton.dev/commit"
+const senderAnnotation = "webhooks.tekton.dev/sender"
+const commitMessageAnnotation = "webhooks.tekton.dev/commit-message"
+const compareURLAnnotation = "webhooks.tekton.dev/compare-url"
+
+// Commit messages are cut down to this many bytes, the subject and summary are what is useful to show
+const maxCommitMessageLength = 1024
+
+// Label values are limited to 63 characters of [A-Za-z0-9_.-], starting and ending with an alphanumeric
+const maxLabelValueLength = 63
+
+var invalidLabelValueChars = regexp.MustCompile("[^A-Za-z0-9_.-]")
+
+// labelValue turns an arbitrary string (e.g. a branch name like feature/foo) into a valid label value
+func labelValue(value string) string {
+	value = invalidLabelValueChars.ReplaceAllString(value, "-")
+	if len(value) > maxLabelValueLength {
+		value = value[0:maxLabelValueLength]
+	}
+	return strings.Trim(value, "-_.")
+}
+
+// Labels only the listener and Tekton set, runs are selected by them so a webhook can't set its own values
+var reservedLabels = map[string]bool{
+	"app": true, gitServerLabel: true, gitOrgLabel: true, gitRepoLabel: true, webhookLabel: true, eventTypeLabel: true,
+	gitBranchLabel: true, gitTagLabel: true, gitPullRequestLabel: true, gitCommitLabel: true, gitSenderLabel: true,
+	triggerLabel: true, completionReportedLabel: true,
+}
+var reservedLabelPrefixes = []string{"tekton.dev/", "webhooks.tekton.dev/"}
+
+// validateCustomLabels checks the labels a webhook adds to its PipelineRuns are valid Kubernetes labels,
+// and not ones the listener or Tekton set
+func validateCustomLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if reservedLabels[key] {
+			return fmt.Errorf("label %s is set by the listener", key)
+		}
+		for _, prefix := range reservedLabelPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				return fmt.Errorf("label %s is set by the listener or Tekton", key)
+			}
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value %q of label %s: %s", value, key, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// addEventLabels labels a PipelineRun with the webhook's custom labels and what it was created for: the webhook,
+// event type, branch or tag, pull request, commit and sender. The listener's own labels win over custom ones
+func addEventLabels(pipelineRun *v1alpha1.PipelineRun, webhook Webhook, buildInformation BuildInformation) {
+	for key, value := range webhook.Labels {
+		if _, set := pipelineRun.Labels[key]; !set {
+			pipelineRun.Labels[key] = value
+		}
+	}
+	labels := map[string]string{
+		webhookLabel:        webhook.Name,
+		eventTypeLabel:      buildInformation.EVENTTYPE,
+		gitBranchLabel:      buildInformation.BRANCH,
+		gitTagLabel:         buildInformation.TAG,
+		gitPullRequestLabel: buildInformation.PULLREQUEST,
+		gitCommitLabel:      buildInformation.COMMITID,
+		gitSenderLabel:      buildInformation.SENDER,
+		triggerLabel:        buildInformation.TRIGGER,
+	}
+	for key, value := range labels {
+		if value = labelValue(value); value != "" {
+			pipelineRun.Labels[key] = value
+		}
+	}
+}
+
+// truncateUTF8 cuts a string down to at most the given number of bytes without cutting a character in two
+func truncateUTF8(value string, length int) string {
+	if len(value) <= length {
+		return value
+	}
+	end := length
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+	return value[0:end]
+}
+
+// addEventAnnotations records on a PipelineRun, in full, the event it was created for
+func addEventAnnotations(pipelineRun *v1alpha1.PipelineRun, webhook Webhook, buildInformation BuildInformation) {
+	if pipelineRun.Annotations == nil {
+		pipelineRun.Annotations = map[string]string{}
+	}
+	message := truncateUTF8(buildInformation.COMMITMESSAGE, maxCommitMessageLength)
+	annotations := map[string]string{
+		webhookAnnotation:       webhook.Name,
+		eventTypeAnnotation:     buildInformation.EVENTTYPE,
+		branchAnnotation:        buildInformation.BRANCH,
+		tagAnnotation:           buildInformation.TAG,
+		pullRequestAnnotation:   buildInformation.PULLREQUEST,
+		commitAnnotation:        buildInformation.COMMITID,
+		senderAnnotation:        buildInformation.SENDER,
+		deliveryIDAnnotation:    buildInformation.DELIVERYID,
+		commitMessageAnnotation: message,
+		compareURLAnnotation:    buildInformation.COMPAREURL,
+		triggeredByAnnotation:   buildInformation.TRIGGEREDBY,
+	}
+	for key, value := range annotations {
+		if value != "" {
+			pipelineRun.Annotations[key] = value
+		}
+	}
+}
+
+// Returns the name of the webhook that created a PipelineRun, from the annotation or the label of older runs
+func pipelineRunWebhook(pipelineRun v1alpha1.PipelineRun) string {
+	if name := pipelineRun.Annotations[webhookAnnotation]; name != "" {
+		return name
+	}
+	return pipelineRun.Labels[webhookLabel]
+}
+
+// annotatePipelineRun sets an annotation on an existing PipelineRun. The Tekton controller updates runs' status
+// at the same time, so the update is retried on conflicts
+func (r Resource) annotatePipelineRun(name, namespace, key, value string) error {
+	pipelineRuns := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pipelineRun, err := pipelineRuns.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pipelineRun.Annotations == nil {
+			pipelineRun.Annotations = map[string]string{}
+		}
+		pipelineRun.Annotations[key] = value
+		_, err = pipelineRuns.Update(pipelineRun)
+		return err
+	})
+}
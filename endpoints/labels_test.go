@@ -0,0 +1,84 @@
+package endpoints
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"master", "master"},
+		{"feature/foo", "feature-foo"},
+		{"release-1.0_rc", "release-1.0_rc"},
+		{"-leading.and.trailing_", "leading.and.trailing"},
+		{"user@example.com", "user-example.com"},
+		{"ünïcode", "n-code"},
+		{"", ""},
+		{strings.Repeat("a", 70), strings.Repeat("a", maxLabelValueLength)},
+		// Trimming happens after truncation so the value doesn't end in a separator
+		{strings.Repeat("a", maxLabelValueLength-1) + "/b", strings.Repeat("a", maxLabelValueLength-1)},
+	}
+	for _, test := range tests {
+		got := labelValue(test.value)
+		if got != test.want {
+			t.Errorf("labelValue(%q) = %q, want %q", test.value, got, test.want)
+		}
+		if errs := validation.IsValidLabelValue(got); len(errs) > 0 {
+			t.Errorf("labelValue(%q) = %q is not a valid label value: %s", test.value, got, strings.Join(errs, "; "))
+		}
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		value  string
+		length int
+		want   string
+	}{
+		{"short", 10, "short"},
+		{"exact", 5, "exact"},
+		{"truncated", 5, "trunc"},
+		// é is two bytes, it is dropped rather than cut in half
+		{"café", 4, "caf"},
+		{"café", 5, "café"},
+		{"日本語", 7, "日本"},
+		{"日本語", 2, ""},
+		{"", 0, ""},
+	}
+	for _, test := range tests {
+		got := truncateUTF8(test.value, test.length)
+		if got != test.want {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", test.value, test.length, got, test.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncateUTF8(%q, %d) = %q is not valid UTF-8", test.value, test.length, got)
+		}
+	}
+}
+
+func TestValidateCustomLabels(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		valid  bool
+	}{
+		{map[string]string{"team": "payments"}, true},
+		{map[string]string{"example.com/team": "payments"}, true},
+		{map[string]string{"team": ""}, true},
+		{map[string]string{"bad key": "value"}, false},
+		{map[string]string{"team": "has spaces"}, false},
+		{map[string]string{"team": strings.Repeat("a", 64)}, false},
+		{map[string]string{gitBranchLabel: "master"}, false},
+		{map[string]string{webhookLabel: "other-webhook"}, false},
+		{map[string]string{"tekton.dev/pipeline": "other-pipeline"}, false},
+	}
+	for _, test := range tests {
+		if err := validateCustomLabels(test.labels); (err == nil) != test.valid {
+			t.Errorf("validateCustomLabels(%v) got error %v, want valid %t", test.labels, err, test.valid)
+		}
+	}
+}
@@ -3,24 +3,36 @@ package endpoints
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	restful "github.com/emicklei/go-restful"
 	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	gh "gopkg.in/go-playground/webhooks.v3/github"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
 )
 
 const gitServerLabel = "gitServer"
 const gitOrgLabel = "gitOrg"
 const gitRepoLabel = "gitRepo"
+const webhookLabel = "webhook"
+const gitBranchLabel = "gitBranch"
+const gitPullRequestLabel = "gitPullRequest"
+const triggerLabel = "trigger"
 const githubEventParameter = "Ce-Github-Event"
 
+// Header with the event type of deliveries GitHub sends straight to the webhook service, as for a GitHub App's own webhook
+const githubEventHeader = "X-GitHub-Event"
+
 // BuildInformation - information required to build a particular commit from a Git repository.
 type BuildInformation struct {
 	REPOURL        string
@@ -29,59 +41,205 @@ type BuildInformation struct {
 	REPONAME       string
 	TIMESTAMP      string
 	SERVICEACCOUNT string
+	BRANCH         string
+	PULLREQUEST    string
+	COMMITMESSAGE  string
+	PRTITLE        string
+	CHANGEDFILES   []string
+	FORK           bool
+	APPROVED       bool
+	PRLABELS       []string
+	EVENTTYPE      string
+	TAG            string
+	PRERELEASE     bool
+	TRIGGER        string
+	TRIGGEREDBY    string
+	PARAMS         map[string]string
+	SENDER         string
+	DELIVERYID     string
+	COMPAREURL     string
+}
+
+// ListenerResponse describes what the listener did with an event
+type ListenerResponse struct {
+	Skipped     bool     `json:"skipped,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+	PipelineRun string   `json:"pipelinerun,omitempty"`
+	Queued      bool     `json:"queued,omitempty"`
+	Cancelled   []string `json:"cancelled,omitempty"`
+}
+
+// respondSkipped tells the sender the event was received but deliberately not built
+func (r Resource) respondSkipped(response *restful.Response, reason string) {
+	r.delivery.skipped(reason)
+	response.WriteHeaderAndJson(http.StatusOK, ListenerResponse{Skipped: true, Reason: reason}, restful.MIME_JSON)
+}
+
+// respondFailed tells the sender the event could not be built, with a server error so that it is delivered again.
+// A dry run responds with what it rendered instead
+func (r Resource) respondFailed(response *restful.Response, err error) {
+	if r.dryRun == nil {
+		RespondError(response, err, http.StatusInternalServerError)
+	}
+}
+
+// Returns the GitHub delivery ID, or the CloudEvents ID the GitHubSource copies it into
+func getDeliveryID(request *restful.Request) string {
+	deliveryID := request.HeaderParameter(githubDeliveryHeader)
+	if deliveryID == "" {
+		deliveryID = request.HeaderParameter(cloudEventIDHeader)
+	}
+	return deliveryID
+}
+
+// Returns the GitHub event type the GitHubSource copies into a CloudEvents header, or else GitHub's own header
+func getEventType(request *restful.Request) string {
+	eventType := request.HeaderParameter(githubEventParameter)
+	if eventType == "" {
+		eventType = request.HeaderParameter(githubEventHeader)
+	}
+	return eventType
 }
 
 func handleWebhook(request *restful.Request, response *restful.Response) {
-	log.Printf("Handle webhook request: %+v", request)
+	logger.Infow("Handle webhook request", "method", request.Request.Method, "path", request.Request.URL.Path)
 	response.Write([]byte("Handle Webhook"))
 }
 
 // handleWebhook should be called when we hit the / endpoint with webhook data. Todo provide proper responses e.g. 503, server errors, 200 if good
 func (r Resource) handleWebhook(request *restful.Request, response *restful.Response) {
-	log.Print("In HandleWebhook code with error handling for a GitHub event...")
+	if request.QueryParameter("dryRun") == "true" && r.dryRun == nil {
+		r.handleDryRun(request, response)
+		return
+	}
+	if r.delivery == nil && r.dryRun == nil {
+		r.handleRecordedWebhook(request, response)
+		return
+	}
+	r, span := r.startEventSpan(request)
+	defer span.End()
+	r.log().Info("In HandleWebhook code with error handling for a GitHub event...")
 	buildInformation := BuildInformation{}
-	log.Printf("Github event name to look for is: %s", githubEventParameter)
-	gitHubEventType := request.HeaderParameter(githubEventParameter)
+	r.log().Infof("Github event name to look for is: %s", githubEventParameter)
+	gitHubEventType := getEventType(request)
 
 	if len(gitHubEventType) < 1 {
-		log.Printf("found header (%s) exists but has no value! Request is: %+v", githubEventParameter, request)
+		r.log().Warnw("found header exists but has no value!", "header", githubEventParameter, "headers", redactHeaders(request.Request.Header))
 		return
 	}
 
 	gitHubEventTypeString := strings.Replace(gitHubEventType, "\"", "", -1)
 
-	log.Printf("GitHub event type is %s", gitHubEventTypeString)
+	r = r.withFields("deliveryID", getDeliveryID(request), "event", gitHubEventTypeString)
+	span.SetAttributes(attribute.String("event", gitHubEventTypeString), attribute.String("delivery", getDeliveryID(request)))
+	r.log().Infof("GitHub event type is %s", gitHubEventTypeString)
+	r.log().Debugw("Event headers", "headers", redactHeaders(request.Request.Header))
 
 	timestamp := getDateTimeAsString()
 
 	if gitHubEventTypeString == "ping" {
 		response.WriteHeader(http.StatusNoContent)
-	} else if gitHubEventTypeString == "push" {
-		log.Print("Handling a push event...")
+		return
+	}
+
+	// GitHub and Knative eventing both retry deliveries, only handle each delivery once
+	deliveryID := getDeliveryID(request)
+	if deliveryID != "" && r.dryRun == nil {
+		duplicate, err := r.isDuplicateDelivery(deliveryID, getPipelineRunNamespace())
+		if err != nil {
+			r.log().Errorf("could not check whether delivery %s is a duplicate, handling it anyway: %s", deliveryID, err)
+		} else if duplicate {
+			r.log().Infof("Skipping duplicate delivery %s", deliveryID)
+			r.respondSkipped(response, fmt.Sprintf("duplicate delivery %s", deliveryID))
+			return
+		} else {
+			// Forget a delivery that could not be handled so that its redelivery is not dropped
+			defer func() {
+				if r.delivery.hasFailed() || response.StatusCode() >= http.StatusInternalServerError {
+					r.forgetDelivery(deliveryID, getPipelineRunNamespace())
+				}
+			}()
+		}
+	}
+
+	if gitHubEventTypeString == "push" {
+		r.log().Info("Handling a push event...")
 
 		webhookData := gh.PushPayload{}
 
 		if err := request.ReadEntity(&webhookData); err != nil {
-			log.Printf("an error occurred decoding webhook data: %s", err)
+			r.log().Errorf("an error occurred decoding webhook data: %s", err)
+			RespondError(response, err, http.StatusBadRequest)
+			return
+		}
+		if err := validatePushPayload(webhookData); err != nil {
+			r.log().Warnf("invalid push event: %s", err)
+			RespondError(response, err, http.StatusBadRequest)
 			return
 		}
 
 		buildInformation.REPOURL = webhookData.Repository.URL
-		buildInformation.SHORTID = webhookData.HeadCommit.ID[0:7]
-		buildInformation.COMMITID = webhookData.HeadCommit.ID
+		buildInformation.EVENTTYPE = gitHubEventTypeString
+		if strings.HasPrefix(webhookData.Ref, tagRefPrefix) {
+			if webhookData.Deleted {
+				r.respondSkipped(response, "tag deleted")
+				return
+			}
+			// A tag can be pushed without a head commit, the tag itself is enough to check out
+			buildInformation.TAG = strings.TrimPrefix(webhookData.Ref, tagRefPrefix)
+			buildInformation.COMMITID = webhookData.HeadCommit.ID
+			if buildInformation.COMMITID == "" {
+				buildInformation.COMMITID = buildInformation.TAG
+			}
+			buildInformation.SHORTID = shortID(buildInformation.COMMITID)
+		} else if webhookData.Deleted {
+			// A deleted branch has no head commit, clean up using the last commit it had
+			buildInformation.EVENTTYPE = branchDeleteEvent
+			buildInformation.SHORTID = webhookData.Before[0:7]
+			buildInformation.COMMITID = webhookData.Before
+			buildInformation.BRANCH = strings.TrimPrefix(webhookData.Ref, branchRefPrefix)
+		} else {
+			buildInformation.SHORTID = webhookData.HeadCommit.ID[0:7]
+			buildInformation.COMMITID = webhookData.HeadCommit.ID
+			buildInformation.BRANCH = strings.TrimPrefix(webhookData.Ref, branchRefPrefix)
+		}
 		buildInformation.REPONAME = webhookData.Repository.Name
 		buildInformation.TIMESTAMP = timestamp
+		buildInformation.COMMITMESSAGE = webhookData.HeadCommit.Message
+		buildInformation.SENDER = webhookData.Sender.Login
+		buildInformation.DELIVERYID = deliveryID
+		buildInformation.COMPAREURL = webhookData.Compare
+		for _, commit := range webhookData.Commits {
+			buildInformation.CHANGEDFILES = append(buildInformation.CHANGEDFILES, commit.Added...)
+			buildInformation.CHANGEDFILES = append(buildInformation.CHANGEDFILES, commit.Modified...)
+			buildInformation.CHANGEDFILES = append(buildInformation.CHANGEDFILES, commit.Removed...)
+		}
 
-		createPipelineRunFromWebhookData(buildInformation, r)
-		log.Printf("Build information for repository %s:%s %s", buildInformation.REPOURL, buildInformation.SHORTID, buildInformation)
+		r = r.withFields("repo", buildInformation.REPOURL)
+		skipReason, err := createPipelineRunFromWebhookData(buildInformation, r)
+		if err != nil {
+			r.respondFailed(response, err)
+			return
+		}
+		if skipReason != "" {
+			r.respondSkipped(response, skipReason)
+			return
+		}
+		r.log().Debugf("Build information for repository %s:%s %+v", buildInformation.REPOURL, buildInformation.SHORTID, buildInformation.forLogging())
 
 	} else if gitHubEventTypeString == "pull_request" {
-		log.Print("Handling a pull request event...")
+		r.log().Info("Handling a pull request event...")
 
 		webhookData := gh.PullRequestPayload{}
 
 		if err := request.ReadEntity(&webhookData); err != nil {
-			log.Printf("an error occurred decoding webhook data: %s", err)
+			r.log().Errorf("an error occurred decoding webhook data: %s", err)
+			RespondError(response, err, http.StatusBadRequest)
+			return
+		}
+		if err := validatePullRequestPayload(webhookData); err != nil {
+			r.log().Warnf("invalid pull request event: %s", err)
+			RespondError(response, err, http.StatusBadRequest)
 			return
 		}
 
@@ -90,18 +248,52 @@ func (r Resource) handleWebhook(request *restful.Request, response *restful.Resp
 		buildInformation.COMMITID = webhookData.PullRequest.Head.Sha
 		buildInformation.REPONAME = webhookData.Repository.Name
 		buildInformation.TIMESTAMP = timestamp
+		buildInformation.EVENTTYPE = gitHubEventTypeString
+		buildInformation.BRANCH = webhookData.PullRequest.Head.Ref
+		buildInformation.PULLREQUEST = strconv.FormatInt(webhookData.Number, 10)
+		buildInformation.PRTITLE = webhookData.PullRequest.Title
+		buildInformation.FORK = webhookData.PullRequest.Head.Repo.FullName != webhookData.PullRequest.Base.Repo.FullName
+		buildInformation.SENDER = webhookData.Sender.Login
+		buildInformation.DELIVERYID = deliveryID
+		buildInformation.COMPAREURL = fmt.Sprintf("%s/compare/%s...%s", webhookData.Repository.HTMLURL,
+			webhookData.PullRequest.Base.Sha, webhookData.PullRequest.Head.Sha)
+		for _, label := range webhookData.PullRequest.Labels {
+			buildInformation.PRLABELS = append(buildInformation.PRLABELS, label.Name)
+		}
+
+		r = r.withFields("repo", buildInformation.REPOURL)
+		skipReason, err := createPipelineRunFromWebhookData(buildInformation, r)
+		if err != nil {
+			r.respondFailed(response, err)
+			return
+		}
+		if skipReason != "" {
+			r.respondSkipped(response, skipReason)
+			return
+		}
+		r.log().Debugf("Build information for repository %s:%s %+v", buildInformation.REPOURL, buildInformation.SHORTID, buildInformation.forLogging())
+
+	} else if gitHubEventTypeString == "issue_comment" {
+		r.log().Info("Handling an issue comment event...")
+		r.handleIssueComment(request, response)
 
-		createPipelineRunFromWebhookData(buildInformation, r)
-		log.Printf("Build information for repository %s:%s %s", buildInformation.REPOURL, buildInformation.SHORTID, buildInformation)
+	} else if gitHubEventTypeString == "release" {
+		r.log().Info("Handling a release event...")
+		r.handleRelease(request, response)
+
+	} else if gitHubEventTypeString == "check_run" {
+		r.log().Info("Handling a check run event...")
+		r.handleCheckRun(request, response)
 
 	} else {
-		log.Print("event wasn't a push or pull event, no action will be taken")
+		r.log().Info("event wasn't a push, pull, issue comment, release or check run event, no action will be taken")
 	}
 }
 
-// This is the main flow that handles building and deploying: given everything we need to kick off a build, do so
-func createPipelineRunFromWebhookData(buildInformation BuildInformation, r Resource) {
-	log.Printf("In createPipelineRunFromWebhookData, build information: %s", buildInformation)
+// This is the main flow that handles building and deploying: given everything we need to kick off a build, do so.
+// Returns the reason when the webhook's configuration says the event should not be built, or why it could not be
+func createPipelineRunFromWebhookData(buildInformation BuildInformation, r Resource) (string, error) {
+	r.log().Debugf("In createPipelineRunFromWebhookData, build information: %+v", buildInformation.forLogging())
 
 	// TODO: Use the dashboard endpoint to create the PipelineRun
 	// Track PR: https://github.com/tektoncd/dashboard/pull/33
@@ -115,66 +307,231 @@ func createPipelineRunFromWebhookData(buildInformation BuildInformation, r Resou
 
 	// The specified service account name is also exposed through the chart's values.yaml: defaulting to "tekton-pipelines".
 
-	pipelineNs := os.Getenv("PIPELINE_RUN_NAMESPACE")
-	if pipelineNs == "" {
-		pipelineNs = "default"
-	}
+	pipelineNs := getPipelineRunNamespace()
 
-	log.Printf("PipelineRuns will be created in the namespace %s", pipelineNs)
+	r.log().Infof("PipelineRuns will be created in the namespace %s", pipelineNs)
 
 	// get information from related githubsource instance
+	_, span := r.startSpan("getGitHubWebhook", trace.WithAttributes(attribute.String("repo", buildInformation.REPOURL)))
 	webhook, err := r.getGitHubWebhook(buildInformation.REPOURL, pipelineNs)
+	endSpan(span, err)
 	if err != nil {
-		log.Printf("Error getting github webhook: %s", err.Error())
-		return
+		r.log().Errorf("Error getting github webhook: %s", err.Error())
+		r.delivery.failed(err)
+		return "", err
+	}
+	r.delivery.matched(webhook)
+	r = r.withFields("webhook", webhook.Name)
+
+	skipReason := r.filterEvent(webhook, buildInformation)
+	// Dry runs don't count, they are not real events
+	if r.dryRun == nil {
+		recordFilterDecision(webhook, skipReason)
+	}
+	if skipReason != "" {
+		r.log().Infof("Not building %s for webhook %s: %s", buildInformation.COMMITID, webhook.Name, skipReason)
+		r.recordSkippedEvent(webhook, buildInformation.EVENTTYPE, buildInformation.COMMITID, skipReason)
+		return skipReason, nil
+	}
+
+	if _, err := r.startPipelineRun(webhook, buildInformation, pipelineNs); err != nil {
+		r.log().Errorf("could not start the PipelineRun for webhook %s: %s", webhook.Name, err)
+		r.delivery.failed(err)
+		return "", err
+	}
+	return "", nil
+}
+
+// startPipelineRun creates the PipelineRun for a webhook, or queues it when the webhook has a concurrency limit.
+// Returns the name of the created PipelineRun, or "" if it was queued
+func (r Resource) startPipelineRun(webhook Webhook, buildInformation BuildInformation, pipelineNs string) (string, error) {
+	if r.dryRun != nil {
+		return r.renderPipelineRun(webhook, buildInformation, pipelineNs)
+	}
+	r.delivery.matched(webhook)
+	// With a concurrency limit in place every event goes through the queue so that events start in order
+	if webhook.MaxConcurrentRuns > 0 || getGlobalMaxConcurrentRuns() > 0 {
+		if err := r.enqueueRun(webhook, buildInformation, pipelineNs); err != nil {
+			r.log().Errorf("could not queue the PipelineRun for webhook %s, error: %s", webhook.Name, err)
+			return "", err
+		}
+		r.recordWebhookEvent(webhook, corev1.EventTypeNormal, pipelineRunQueuedReason,
+			fmt.Sprintf("Queued the PipelineRun for %s", buildInformation.COMMITID))
+		r.processRunQueue(pipelineNs)
+		r.delivery.started("")
+		return "", nil
+	}
+	pipelineRunName, err := r.createPipelineRunWithRetry(webhook, buildInformation, pipelineNs)
+	if err == nil {
+		r.delivery.started(pipelineRunName)
+	}
+	return pipelineRunName, err
+}
+
+// createPipelineRun creates the PipelineResources and PipelineRun for a webhook event in the given namespace,
+// named with the suffix, returning the name of the PipelineRun. Objects already there from an earlier attempt with
+// the same suffix are taken as created
+func (r Resource) createPipelineRun(webhook Webhook, buildInformation BuildInformation, pipelineNs, suffix string) (string, error) {
+	pipelineResources, pipelineRunData, err := r.definePipelineRunObjects(webhook, buildInformation, pipelineNs, suffix)
+	if err != nil {
+		return "", err
+	}
+
+	r.log().Info("Creating PipelineResources next...")
+
+	// Either everything is created or nothing is, a PipelineRun must not reference missing resources
+	created := []string{}
+	for _, pipelineResource := range pipelineResources {
+		_, span := r.startSpan("createPipelineResource", trace.WithAttributes(attribute.String("pipelineResource", pipelineResource.Name)))
+		createdPipelineResource, err := r.TektonClient.TektonV1alpha1().PipelineResources(pipelineNs).Create(pipelineResource)
+		endSpan(span, err)
+		if k8serrors.IsAlreadyExists(err) {
+			createdPipelineResource, err = pipelineResource, nil
+		}
+		if err != nil {
+			r.log().Errorf("could not create pipeline %s resource to be used in the pipeline, error: %s", pipelineResource.Spec.Type, err)
+			return "", r.rollBackPipelineResources(created, pipelineNs,
+				fmt.Sprintf("the %s PipelineResource %s", pipelineResource.Spec.Type, pipelineResource.Name), err)
+		}
+		r.log().Infof("Created pipeline %s resource %s successfully", pipelineResource.Spec.Type, createdPipelineResource.Name)
+		created = append(created, createdPipelineResource.Name)
 	}
+
+	// Marks the run for its completion to be reported
+	pipelineRunData.Labels[completionReportedLabel] = "false"
+	if traceID := r.traceID(); traceID != "" {
+		if pipelineRunData.Annotations == nil {
+			pipelineRunData.Annotations = map[string]string{}
+		}
+		pipelineRunData.Annotations[traceIDAnnotation] = traceID
+	}
+
+	r.log().Infof("Creating a new PipelineRun named %s in the namespace %s using the service account %s", pipelineRunData.Name, pipelineNs, pipelineRunData.Spec.ServiceAccount)
+
+	_, span := r.startSpan("createPipelineRun", trace.WithAttributes(attribute.String("pipelineRun", pipelineRunData.Name)))
+	pipelineRun, err := r.TektonClient.TektonV1alpha1().PipelineRuns(pipelineNs).Create(pipelineRunData)
+	endSpan(span, err)
+	// A timed out request can still have created the PipelineRun, as can an earlier attempt
+	if err != nil {
+		r.log().Errorf("error creating the PipelineRun: %s", err)
+		existing, getErr := r.TektonClient.TektonV1alpha1().PipelineRuns(pipelineNs).Get(pipelineRunData.Name, metav1.GetOptions{})
+		switch {
+		case getErr == nil:
+			r.log().Infof("PipelineRun %s was created despite the error", pipelineRunData.Name)
+			pipelineRun, err = existing, nil
+		case k8serrors.IsNotFound(getErr):
+			return "", r.rollBackPipelineResources(created, pipelineNs, fmt.Sprintf("the PipelineRun %s", pipelineRunData.Name), err)
+		default:
+			// The PipelineRun may be using its resources, they are left for a retry with the same names to find
+			r.log().Errorf("could not check whether PipelineRun %s was created, keeping its PipelineResources, error: %s", pipelineRunData.Name, getErr)
+			return "", creationError{message: fmt.Sprintf("could not create the PipelineRun %s: %s", pipelineRunData.Name, err), cause: err}
+		}
+	}
+	r.log().Infow("PipelineRun created", "pipelineRun", pipelineRun.Name)
+	// Runs this one makes obsolete are only cancelled once it exists, so a failed creation leaves them running
+	if webhook.CancelSuperseded {
+		if superseded := r.cancelSupersededPipelineRuns(webhook, buildInformation, pipelineNs, pipelineRun.Name); len(superseded) > 0 {
+			if err := r.annotatePipelineRun(pipelineRun.Name, pipelineNs, supersededAnnotation, strings.Join(superseded, ",")); err != nil {
+				r.log().Errorf("could not record which PipelineRuns %s superseded, error: %s", pipelineRun.Name, err)
+			}
+		}
+	}
+	r.recordWebhookEvent(webhook, corev1.EventTypeNormal, pipelineRunCreatedReason,
+		fmt.Sprintf("Created PipelineRun %s for %s", pipelineRun.Name, buildInformation.COMMITID))
+	// Sending is retried, so don't keep the sender of the event waiting on it
+	go r.emitRunEvent(runStartedEventType, webhook, *pipelineRun)
+	go r.createCheckRun(webhook, *pipelineRun)
+	return pipelineRun.Name, nil
+}
+
+// creationError is a failure to create a PipelineRun or its resources, keeping the API error so callers can tell whether to retry
+type creationError struct {
+	message string
+	cause   error
+}
+
+func (e creationError) Error() string {
+	return e.message
+}
+
+// rollBackPipelineResources deletes the PipelineResources created for a PipelineRun that could not be created,
+// returning a single error describing the failure and anything the rollback left behind
+func (r Resource) rollBackPipelineResources(names []string, namespace, failed string, cause error) error {
+	orphans := []string{}
+	for _, name := range names {
+		err := r.TektonClient.TektonV1alpha1().PipelineResources(namespace).Delete(name, &metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			r.log().Errorf("could not roll back PipelineResource %s, error: %s", name, err)
+			orphans = append(orphans, name)
+			continue
+		}
+		r.log().Infof("Rolled back PipelineResource %s", name)
+	}
+	message := fmt.Sprintf("could not create %s: %s", failed, cause)
+	if len(orphans) > 0 {
+		message = fmt.Sprintf("%s, and could not delete PipelineResources %s", message, strings.Join(orphans, ", "))
+	}
+	return creationError{message: message, cause: cause}
+}
+
+// newRunSuffix returns a suffix for the names of a PipelineRun and its PipelineResources. Timestamps only change
+// every second, the random part keeps the names of events in the same second apart
+func newRunSuffix() string {
+	return fmt.Sprintf("%s-%s", getDateTimeAsString(), rand.String(5))
+}
+
+// definePipelineRunObjects returns the PipelineResources and PipelineRun for a webhook event without creating them,
+// named with the suffix
+func (r Resource) definePipelineRunObjects(webhook Webhook, buildInformation BuildInformation, pipelineNs, suffix string) ([]*v1alpha1.PipelineResource, *v1alpha1.PipelineRun, error) {
 	registrySecret := webhook.RegistrySecret
 	helmSecret := webhook.HelmSecret
 	pipelineTemplateName := webhook.Pipeline
+	if buildInformation.TAG != "" {
+		pipelineTemplateName = webhook.ReleasePipeline
+	} else if buildInformation.EVENTTYPE == branchDeleteEvent {
+		pipelineTemplateName = webhook.CleanupPipeline
+	}
 	saName := webhook.ServiceAccount
 	if saName == "" {
 		saName = "default"
 	}
 
+	// Under the untrusted policy code from forks that nobody approved doesn't get the webhook's service account or secrets
+	if isUntrusted(webhook, buildInformation) {
+		r.log().Infof("Pull request %s is from a fork and not approved, building it without secrets", buildInformation.PULLREQUEST)
+		registrySecret = ""
+		helmSecret = ""
+		saName = webhook.UntrustedServiceAccount
+		if saName == "" {
+			saName = "default"
+		}
+	}
+
 	// Assumes you've already applied the yml: so the pipeline definition and its tasks must exist upfront.
-	startTime := getDateTimeAsString()
-	generatedPipelineRunName := fmt.Sprintf("%s-%s", webhook.Name, startTime)
+	generatedPipelineRunName := fmt.Sprintf("%s-%s", webhook.Name, suffix)
 
 	// Unique names are required so timestamp them.
-	imageResourceName := fmt.Sprintf("%s-docker-image-%s", webhook.Name, startTime)
-	gitResourceName := fmt.Sprintf("%s-git-source-%s", webhook.Name, startTime)
+	imageResourceName := fmt.Sprintf("%s-docker-image-%s", webhook.Name, suffix)
+	gitResourceName := fmt.Sprintf("%s-git-source-%s", webhook.Name, suffix)
 
+	_, span := r.startSpan("getPipeline", trace.WithAttributes(attribute.String("pipeline", pipelineTemplateName)))
 	pipeline, err := r.getPipelineImpl(pipelineTemplateName, pipelineNs)
+	endSpan(span, err)
 	if err != nil {
-		log.Printf("could not find the pipeline template %s in namespace %s", pipelineTemplateName, pipelineNs)
-		return
+		r.log().Errorf("could not find the pipeline template %s in namespace %s", pipelineTemplateName, pipelineNs)
+		return nil, nil, err
 	}
-	log.Printf("Found the pipeline template %s OK", pipelineTemplateName)
-
-	log.Print("Creating PipelineResources next...")
+	r.log().Infof("Found the pipeline template %s OK", pipelineTemplateName)
 
 	registryURL := os.Getenv("DOCKER_REGISTRY_LOCATION")
 	urlToUse := fmt.Sprintf("%s/%s:%s", registryURL, strings.ToLower(buildInformation.REPONAME), buildInformation.SHORTID)
-	log.Printf("Pushing the image to %s", urlToUse)
+	r.log().Infof("Pushing the image to %s", urlToUse)
 
 	paramsForImageResource := []v1alpha1.Param{{Name: "url", Value: urlToUse}}
 	pipelineImageResource := definePipelineResource(imageResourceName, pipelineNs, paramsForImageResource, "image")
-	createdPipelineImageResource, err := r.TektonClient.TektonV1alpha1().PipelineResources(pipelineNs).Create(pipelineImageResource)
-	if err != nil {
-		log.Printf("could not create pipeline image resource to be used in the pipeline, error: %s", err)
-	} else {
-		log.Printf("Created pipeline image resource %s successfully", createdPipelineImageResource.Name)
-	}
 
 	paramsForGitResource := []v1alpha1.Param{{Name: "revision", Value: buildInformation.COMMITID}, {Name: "url", Value: buildInformation.REPOURL}}
 	pipelineGitResource := definePipelineResource(gitResourceName, pipelineNs, paramsForGitResource, "git")
-	createdPipelineGitResource, err := r.TektonClient.TektonV1alpha1().PipelineResources(pipelineNs).Create(pipelineGitResource)
-
-	if err != nil {
-		log.Printf("could not create pipeline git resource to be used in the pipeline, error: %s", err)
-	} else {
-		log.Printf("Created pipeline git resource %s successfully", createdPipelineGitResource.Name)
-	}
 
 	gitResourceRef := v1alpha1.PipelineResourceRef{Name: gitResourceName}
 	imageResourceRef := v1alpha1.PipelineResourceRef{Name: imageResourceName}
@@ -197,33 +554,65 @@ func createPipelineRunFromWebhookData(buildInformation BuildInformation, r Resou
 	if helmSecret != "" {
 		params = append(params, v1alpha1.Param{Name: "helm-secret", Value: helmSecret})
 	}
+	if buildInformation.TAG != "" {
+		params = append(params, releaseParams(buildInformation)...)
+	}
+	if buildInformation.EVENTTYPE == branchDeleteEvent {
+		params = append(params, v1alpha1.Param{Name: "branch", Value: buildInformation.BRANCH})
+	}
+	params = overrideParams(params, buildInformation.PARAMS)
 
 	// PipelineRun yml defines the references to the above named resources.
 	pipelineRunData, err := definePipelineRun(generatedPipelineRunName, pipelineNs, saName, buildInformation.REPOURL,
 		pipeline, v1alpha1.PipelineTriggerTypeManual, resources, params)
 
-	log.Printf("Creating a new PipelineRun named %s in the namespace %s using the service account %s", generatedPipelineRunName, pipelineNs, saName)
-
-	pipelineRun, err := r.TektonClient.TektonV1alpha1().PipelineRuns(pipelineNs).Create(pipelineRunData)
 	if err != nil {
-		log.Printf("error creating the PipelineRun: %s", err)
-		return
+		r.log().Errorf("error defining the PipelineRun: %s", err)
+		return nil, nil, err
+	}
+	addEventLabels(pipelineRunData, webhook, buildInformation)
+	addEventAnnotations(pipelineRunData, webhook, buildInformation)
+	if buildInformation.TRIGGEREDBY != "" {
+		pipelineRunData.Spec.Trigger.Name = buildInformation.TRIGGEREDBY
+	}
+	return []*v1alpha1.PipelineResource{pipelineImageResource, pipelineGitResource}, pipelineRunData, nil
+}
+
+// overrideParams replaces the values of params with the same name as an override and adds the other overrides
+func overrideParams(params []v1alpha1.Param, overrides map[string]string) []v1alpha1.Param {
+	remaining := make(map[string]string)
+	for name, value := range overrides {
+		remaining[name] = value
+	}
+	for i, param := range params {
+		if value, ok := remaining[param.Name]; ok {
+			params[i].Value = value
+			delete(remaining, param.Name)
+		}
 	}
-	log.Printf("PipelineRun created: %+v", pipelineRun)
+	names := []string{}
+	for name := range remaining {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		params = append(params, v1alpha1.Param{Name: name, Value: remaining[name]})
+	}
+	return params
 }
 
 /* Get all pipelines in a given namespace: the caller needs to handle any errors,
 an empty v1alpha1.Pipeline{} is returned if no pipeline is found */
 func (r Resource) getPipelineImpl(name, namespace string) (v1alpha1.Pipeline, error) {
-	log.Printf("in getPipelineImpl, name %s, namespace %s", name, namespace)
+	r.log().Debugf("in getPipelineImpl, name %s, namespace %s", name, namespace)
 
 	pipelines := r.TektonClient.TektonV1alpha1().Pipelines(namespace)
 	pipeline, err := pipelines.Get(name, metav1.GetOptions{})
 	if err != nil {
-		log.Printf("could not retrieve the pipeline called %s in namespace %s", name, namespace)
+		r.log().Errorf("could not retrieve the pipeline called %s in namespace %s", name, namespace)
 		return v1alpha1.Pipeline{}, err
 	}
-	log.Print("Found the pipeline definition OK")
+	r.log().Info("Found the pipeline definition OK")
 	return *pipeline, nil
 }
 
@@ -253,7 +642,7 @@ func definePipelineRun(pipelineRunName, namespace, saName, repoURL string,
 	if repoURL != "" {
 		gitServer, gitOrg, gitRepo, err = getGitValues(repoURL)
 		if err != nil {
-			log.Printf("there was an error getting the Git values: %s", err)
+			logger.Errorf("there was an error getting the Git values: %s", err)
 			return &v1alpha1.PipelineRun{}, err
 		}
 	}
@@ -310,6 +699,15 @@ func getGitValues(url string) (gitServer, gitOrg, gitRepo string, err error) {
 	return gitServer, gitOrg, gitRepo, nil
 }
 
+// Returns the namespace PipelineRuns are created in, set through PIPELINE_RUN_NAMESPACE
+func getPipelineRunNamespace() string {
+	pipelineNs := os.Getenv("PIPELINE_RUN_NAMESPACE")
+	if pipelineNs == "" {
+		pipelineNs = "default"
+	}
+	return pipelineNs
+}
+
 func getDateTimeAsString() string {
 	return strconv.FormatInt(time.Now().Unix(), 10)
 }
@@ -0,0 +1,111 @@
+package endpoints
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Value logged in place of anything sensitive
+const redacted = "[REDACTED]"
+
+// Headers carrying credentials or signatures, their values are never logged or recorded
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Hub-Signature":     true,
+	"X-Hub-Signature-256": true,
+	"X-Gitlab-Token":      true,
+}
+
+// logger is for code with no event at hand, code handling an event uses Resource.log to get the event's fields
+var logger = newLogger()
+
+/* Create the JSON logger at the level set through LOG_LEVEL: debug, info (the default), warn or error.
+Header values are redacted unless LOG_REDACT is false, which should only be needed to debug senders */
+func newLogger() *zap.SugaredLogger {
+	config := zap.NewProductionConfig()
+	config.EncoderConfig.TimeKey = "time"
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(value)); err == nil {
+			config.Level = zap.NewAtomicLevelAt(level)
+		}
+	}
+	built, err := config.Build()
+	if err != nil {
+		built = zap.NewNop()
+	}
+	return built.Sugar()
+}
+
+// Logger returns the logger for the commands to share
+func Logger() *zap.SugaredLogger {
+	return logger
+}
+
+// log returns the logger with the fields of the event being handled, e.g. delivery ID, repo, webhook and PipelineRun
+func (r Resource) log() *zap.SugaredLogger {
+	if r.logger != nil {
+		return r.logger
+	}
+	return logger
+}
+
+// withFields returns a copy of the Resource whose logger adds the given fields, as key value pairs
+func (r Resource) withFields(keysAndValues ...interface{}) Resource {
+	r.logger = r.log().With(keysAndValues...)
+	return r
+}
+
+func isRedactionEnabled() bool {
+	return os.Getenv("LOG_REDACT") != "false"
+}
+
+// redactHeaders returns the headers as a map for logging, with the values of sensitive headers redacted
+func redactHeaders(header http.Header) map[string]string {
+	result := make(map[string]string)
+	redact := isRedactionEnabled()
+	for name, values := range header {
+		if redact && sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			result[name] = redacted
+			continue
+		}
+		result[name] = strings.Join(values, ",")
+	}
+	return result
+}
+
+// redactParams returns the parameter overrides for logging with their values redacted, as they can carry credentials
+func redactParams(params map[string]string) map[string]string {
+	if params == nil || !isRedactionEnabled() {
+		return params
+	}
+	result := make(map[string]string, len(params))
+	for name := range params {
+		result[name] = redacted
+	}
+	return result
+}
+
+// forLogging returns a copy of the build information that can be logged, with the parameter overrides redacted
+func (b BuildInformation) forLogging() BuildInformation {
+	b.PARAMS = redactParams(b.PARAMS)
+	return b
+}
+
+// webhookNames lists the names of webhooks for logging, rather than logging everything about them
+func webhookNames(webhooks map[string]Webhook) []string {
+	names := []string{}
+	for name := range webhooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
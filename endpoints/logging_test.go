@@ -0,0 +1,36 @@
+package endpoints
+
+import (
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	header := http.Header{"X-Hub-Signature": []string{"sha1=abc"}, "X-Github-Event": []string{"push"}}
+	want := map[string]string{"X-Hub-Signature": redacted, "X-Github-Event": "push"}
+	if got := redactHeaders(header); !reflect.DeepEqual(got, want) {
+		t.Errorf("redactHeaders() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildInformationForLogging(t *testing.T) {
+	buildInformation := BuildInformation{REPOURL: "https://github.com/org/repo", PARAMS: map[string]string{"api-token": "secret"}}
+	logged := buildInformation.forLogging()
+	if want := map[string]string{"api-token": redacted}; !reflect.DeepEqual(logged.PARAMS, want) {
+		t.Errorf("got params %v, want %v", logged.PARAMS, want)
+	}
+	if logged.REPOURL != buildInformation.REPOURL {
+		t.Errorf("got repository %q, want %q", logged.REPOURL, buildInformation.REPOURL)
+	}
+	if buildInformation.PARAMS["api-token"] != "secret" {
+		t.Error("redacting the params for logging changed the build information")
+	}
+
+	os.Setenv("LOG_REDACT", "false")
+	defer os.Unsetenv("LOG_REDACT")
+	if got := buildInformation.forLogging().PARAMS["api-token"]; got != "secret" {
+		t.Errorf("got %q with redaction off, want the value", got)
+	}
+}
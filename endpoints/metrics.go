@@ -0,0 +1,166 @@
+package endpoints
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/tools/metrics"
+)
+
+const metricsNamespace = "tekton_webhooks"
+
+// The only provider events come from for now
+const githubProvider = "github"
+
+var (
+	eventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_total",
+		Help:      "Events received by the listener, by the webhook they matched.",
+	}, []string{"provider", "event", "webhook"})
+
+	filterDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "filter_decisions_total",
+		Help:      "Decisions of webhook filters on whether to build events, with the reason for skipped events.",
+	}, []string{"webhook", "decision", "reason"})
+
+	pipelineRunCreations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "pipelinerun_creations_total",
+		Help:      "Attempts at creating PipelineRuns, including retries, by result.",
+	}, []string{"webhook", "result"})
+
+	kubernetesRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "kubernetes_request_duration_seconds",
+		Help:      "Latency of Kubernetes API requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"verb", "resource"})
+
+	kubernetesRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kubernetes_requests_total",
+		Help:      "Kubernetes API requests by response code.",
+	}, []string{"method", "code"})
+
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Requests served, by route and response status.",
+	}, []string{"route", "method", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(eventsReceived, filterDecisions, pipelineRunCreations, kubernetesRequestDuration, kubernetesRequests, apiRequests)
+}
+
+// Label values are kept to a fixed set, the values in events are up to whoever sends them
+var eventLabels = map[string]bool{
+	"push":          true,
+	"pull_request":  true,
+	"issue_comment": true,
+	"release":       true,
+	"check_run":     true,
+	"ping":          true,
+}
+
+// Skip reasons can name users and directives, they are counted by the kind of reason
+var filterReasonLabels = []struct {
+	prefix, label string
+}{
+	{noReleasePipelineReason, "no_release_pipeline"},
+	{releaseTriggerReason, "release_trigger"},
+	{noCleanupPipelineReason, "no_cleanup_pipeline"},
+	{forkSkippedReason, "fork"},
+	{forkApprovalReason, "fork_not_approved"},
+	{skipDirectiveReason, "skip_directive"},
+	{changedFilesUnknownReason, "changed_files_unknown"},
+	{pathsReason, "paths"},
+}
+
+// recordEvent counts an event received for a webhook, the webhook is "" when the event matched none
+func recordEvent(eventType, webhook string) {
+	if !eventLabels[eventType] {
+		eventType = "other"
+	}
+	eventsReceived.WithLabelValues(githubProvider, eventType, webhook).Inc()
+}
+
+// recordFilterDecision counts whether a webhook's filters let an event be built
+func recordFilterDecision(webhook Webhook, skipReason string) {
+	if skipReason == "" {
+		filterDecisions.WithLabelValues(webhook.Name, "build", "").Inc()
+		return
+	}
+	filterDecisions.WithLabelValues(webhook.Name, "skip", filterReasonLabel(skipReason)).Inc()
+}
+
+func filterReasonLabel(skipReason string) string {
+	for _, reason := range filterReasonLabels {
+		if strings.HasPrefix(skipReason, reason.prefix) {
+			return reason.label
+		}
+	}
+	return "other"
+}
+
+func recordPipelineRunCreation(webhook Webhook, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	pipelineRunCreations.WithLabelValues(webhook.Name, result).Inc()
+}
+
+// kubernetesMetrics receives the latency and result of every request the Kubernetes clients make
+type kubernetesMetrics struct{}
+
+func (kubernetesMetrics) Observe(verb string, u url.URL, latency time.Duration) {
+	kubernetesRequestDuration.WithLabelValues(verb, apiResource(u.Path)).Observe(latency.Seconds())
+}
+
+func (kubernetesMetrics) Increment(code string, method string, host string) {
+	kubernetesRequests.WithLabelValues(method, code).Inc()
+}
+
+// apiResource returns the resource a Kubernetes API path is for, e.g. pipelineruns for
+// /apis/tekton.dev/v1alpha1/namespaces/default/pipelineruns/my-run
+func apiResource(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) > 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) > 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return "unknown"
+	}
+	if len(parts) > 2 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+	return parts[0]
+}
+
+// metricsFilter counts the requests to each route by response status
+func metricsFilter(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	chain.ProcessFilter(request, response)
+	route := request.SelectedRoutePath()
+	if route == "" {
+		route = "unmatched"
+	}
+	apiRequests.WithLabelValues(route, request.Request.Method, strconv.Itoa(response.StatusCode())).Inc()
+}
+
+// RegisterMetrics serves Prometheus metrics on /metrics and starts collecting them for the container's routes
+// and the Kubernetes API requests made
+func RegisterMetrics(container *restful.Container) {
+	metrics.Register(kubernetesMetrics{}, kubernetesMetrics{})
+	container.Filter(metricsFilter)
+	container.Handle("/metrics", promhttp.Handler())
+}
@@ -0,0 +1,27 @@
+package endpoints
+
+import (
+	"testing"
+)
+
+func TestFilterReasonLabel(t *testing.T) {
+	fork := BuildInformation{FORK: true}
+	tests := []struct {
+		reason, want string
+	}{
+		{checkReleaseTrigger(Webhook{}, BuildInformation{TAG: "v1.0.0"}), "no_release_pipeline"},
+		{checkReleaseTrigger(Webhook{ReleasePipeline: "release"}, BuildInformation{TAG: "v1.0.0", EVENTTYPE: "release"}), "release_trigger"},
+		{checkForkPolicy(Webhook{ForkPolicy: forkPolicySkip}, fork), "fork"},
+		{checkForkPolicy(Webhook{ForkPolicy: forkPolicyApprove}, fork), "fork_not_approved"},
+		{Resource{}.filterEvent(Webhook{}, BuildInformation{EVENTTYPE: branchDeleteEvent}), "no_cleanup_pipeline"},
+		{Resource{}.filterEvent(Webhook{}, BuildInformation{COMMITMESSAGE: "docs [skip ci]"}), "skip_directive"},
+		{Resource{}.filterEvent(Webhook{IncludePaths: []string{"src/**"}}, BuildInformation{CHANGEDFILES: []string{"README.md"}}), "paths"},
+		{changedFilesUnknownReason, "changed_files_unknown"},
+		{"the label value of anything else", "other"},
+	}
+	for _, test := range tests {
+		if got := filterReasonLabel(test.reason); got != test.want {
+			t.Errorf("filterReasonLabel(%q) = %q, want %q", test.reason, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Formats of notification messages
+const (
+	slackFormat = "slack"
+	teamsFormat = "teams"
+	jsonFormat  = "json"
+)
+
+// When notifications are sent: for failed runs, for the first successful run after a failure, or for every run
+const (
+	notifyOnFailure  = "failure"
+	notifyOnRecovery = "recovery"
+	notifyAlways     = "always"
+)
+
+// Key of the notification URL in its secret when none is given
+const defaultNotificationURLKey = "url"
+
+// Sending a notification is attempted 4 times over about 7 seconds
+var notifyBackoff = wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: 4}
+
+// Client for the requests the extension sends out, such as notifications
+var outboundClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notification is where and when to send a message about a webhook's completed PipelineRuns.
+// The URL is kept in a secret in the namespace of the PipelineRuns as it usually holds a token
+type Notification struct {
+	Format    string   `json:"format"`
+	On        []string `json:"on,omitempty"`
+	URLSecret string   `json:"urlsecret"`
+	URLKey    string   `json:"urlkey,omitempty"`
+}
+
+// RunNotification is the message about a completed PipelineRun, as sent in the plain JSON format
+type RunNotification struct {
+	Webhook     string `json:"webhook"`
+	PipelineRun string `json:"pipelinerun"`
+	Namespace   string `json:"namespace"`
+	Repository  string `json:"repository"`
+	Commit      string `json:"commit"`
+	Branch      string `json:"branch,omitempty"`
+	Tag         string `json:"tag,omitempty"`
+	PullRequest string `json:"pullrequest,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Status      string `json:"status"`
+	Recovered   bool   `json:"recovered,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Duration    int64  `json:"duration,omitempty"`
+	Link        string `json:"link"`
+}
+
+// validateNotifications checks the notifications of a webhook can be sent
+func validateNotifications(notifications []Notification) error {
+	for _, notification := range notifications {
+		switch notification.Format {
+		case slackFormat, teamsFormat, jsonFormat:
+		default:
+			return fmt.Errorf("notification format must be %s, %s or %s, but %q was given", slackFormat, teamsFormat, jsonFormat, notification.Format)
+		}
+		for _, on := range notification.On {
+			switch on {
+			case notifyOnFailure, notifyOnRecovery, notifyAlways:
+			default:
+				return fmt.Errorf("notifications are sent on %s, %s or %s, but %q was given", notifyOnFailure, notifyOnRecovery, notifyAlways, on)
+			}
+		}
+		if notification.URLSecret == "" {
+			return fmt.Errorf("the %s notification needs the name of the secret holding its URL", notification.Format)
+		}
+	}
+	return nil
+}
+
+// shouldNotify returns true if a notification is sent for a run with the given status, when the previous run failed or not
+func shouldNotify(notification Notification, status string, previousFailed bool) bool {
+	on := notification.On
+	if len(on) == 0 {
+		on = []string{notifyOnFailure, notifyOnRecovery}
+	}
+	for _, when := range on {
+		switch {
+		case when == notifyAlways:
+			return true
+		case when == notifyOnFailure && status == runFailed:
+			return true
+		case when == notifyOnRecovery && status == runSucceeded && previousFailed:
+			return true
+		}
+	}
+	return false
+}
+
+// previousRunFailed returns true if the completed run before this one, for the same branch or pull request, failed
+func (r Resource) previousRunFailed(webhook Webhook, run RunSummary, namespace string) bool {
+	runs, err := r.listWebhookRuns(webhook.Name, namespace, run.Branch, run.PullRequest, "", "")
+	if err != nil {
+		r.log().Errorf("could not find the run before %s, error: %s", run.Name, err)
+		return false
+	}
+	// Runs are most recent first, the previous run is the first completed one after this one
+	seen := false
+	for _, other := range runs {
+		if other.Name == run.Name {
+			seen = true
+			continue
+		}
+		if !seen || (run.PullRequest == "" && other.PullRequest != "") {
+			continue
+		}
+		switch other.Status {
+		case runSucceeded:
+			return false
+		case runFailed:
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the link to a PipelineRun in the Tekton dashboard at DASHBOARD_URL, or else to its commit
+func runLink(repoURL, commit, namespace, pipelineRunName string) string {
+	if dashboard := os.Getenv("DASHBOARD_URL"); dashboard != "" {
+		return fmt.Sprintf("%s/#/namespaces/%s/pipelineruns/%s", strings.TrimSuffix(dashboard, "/"), namespace, pipelineRunName)
+	}
+	return fmt.Sprintf("%s/commit/%s", strings.TrimSuffix(repoURL, ".git"), commit)
+}
+
+// newRunNotification describes a webhook's completed PipelineRun
+func newRunNotification(webhook Webhook, pipelineRun v1alpha1.PipelineRun, recovered bool) RunNotification {
+	run := summarizeRun(pipelineRun)
+	return RunNotification{
+		Webhook:     webhook.Name,
+		PipelineRun: pipelineRun.Name,
+		Namespace:   pipelineRun.Namespace,
+		Repository:  webhook.GitRepositoryURL,
+		Commit:      run.Commit,
+		Branch:      run.Branch,
+		Tag:         run.Tag,
+		PullRequest: run.PullRequest,
+		Author:      run.Sender,
+		Status:      run.Status,
+		Recovered:   recovered,
+		Reason:      run.Reason,
+		Duration:    run.Duration,
+		Link:        runLink(webhook.GitRepositoryURL, run.Commit, pipelineRun.Namespace, pipelineRun.Name),
+	}
+}
+
+// The one line summary of a notification, e.g. "org/repo: PipelineRun my-run failed for master at 1a2b3c4"
+func (n RunNotification) summary() string {
+	status := n.Status
+	if n.Recovered {
+		status = "succeeded again"
+	}
+	ref := n.Branch
+	if n.PullRequest != "" {
+		ref = "pull request " + n.PullRequest
+	} else if n.Tag != "" {
+		ref = n.Tag
+	}
+	return fmt.Sprintf("%s: PipelineRun %s %s for %s at %s", repoName(n.Repository), n.PipelineRun, status, ref, shortID(n.Commit))
+}
+
+// The details of a notification in the order they are shown
+func (n RunNotification) facts() [][2]string {
+	facts := [][2]string{{"Repository", n.Repository}, {"Commit", n.Commit}}
+	if n.Author != "" {
+		facts = append(facts, [2]string{"Author", n.Author})
+	}
+	facts = append(facts, [2]string{"Status", n.Status})
+	if n.Reason != "" {
+		facts = append(facts, [2]string{"Reason", n.Reason})
+	}
+	if n.Duration > 0 {
+		facts = append(facts, [2]string{"Duration", (time.Duration(n.Duration) * time.Second).String()})
+	}
+	return facts
+}
+
+// Returns the org/repo of a repository URL, or the URL if it isn't one
+func repoName(repoURL string) string {
+	_, gitOrg, gitRepo, err := getGitValues(repoURL)
+	if err != nil {
+		return repoURL
+	}
+	return gitOrg + "/" + strings.TrimSuffix(gitRepo, ".git")
+}
+
+// Colour of the notification for a run's status, as a hex RGB value
+func notificationColor(status string) string {
+	switch status {
+	case runSucceeded:
+		return "2EB886"
+	case runFailed:
+		return "E05D44"
+	}
+	return "9F9F9F"
+}
+
+// formatNotification returns the body of a notification in the given format
+func formatNotification(format string, n RunNotification) interface{} {
+	switch format {
+	case slackFormat:
+		fields := []map[string]interface{}{}
+		for _, fact := range n.facts() {
+			fields = append(fields, map[string]interface{}{"title": fact[0], "value": fact[1], "short": fact[0] != "Repository"})
+		}
+		return map[string]interface{}{
+			"text": n.summary(),
+			"attachments": []map[string]interface{}{{
+				"color":      "#" + notificationColor(n.Status),
+				"title":      n.PipelineRun,
+				"title_link": n.Link,
+				"fields":     fields,
+			}},
+		}
+	case teamsFormat:
+		facts := []map[string]string{}
+		for _, fact := range n.facts() {
+			facts = append(facts, map[string]string{"name": fact[0], "value": fact[1]})
+		}
+		return map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"themeColor": notificationColor(n.Status),
+			"summary":    n.summary(),
+			"sections":   []map[string]interface{}{{"activityTitle": n.summary(), "facts": facts}},
+			"potentialAction": []map[string]interface{}{{
+				"@type":   "OpenUri",
+				"name":    "View PipelineRun",
+				"targets": []map[string]string{{"os": "default", "uri": n.Link}},
+			}},
+		}
+	}
+	return n
+}
+
+// Returns true if sending again may succeed after the given response status
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// postJSON sends a JSON body, retrying with exponential backoff when the receiver is unavailable
+func postJSON(url string, body interface{}, headers map[string]string) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	err = wait.ExponentialBackoff(notifyBackoff, func() (bool, error) {
+		request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+		if err != nil {
+			return false, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			request.Header.Set(name, value)
+		}
+		response, err := outboundClient.Do(request)
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		response.Body.Close()
+		if response.StatusCode < 300 {
+			return true, nil
+		}
+		lastErr = fmt.Errorf("%s responded %s", request.URL.Host, response.Status)
+		if !isRetryableStatus(response.StatusCode) {
+			return false, lastErr
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return lastErr
+	}
+	return err
+}
+
+// Returns the URL to send a notification to from its secret
+func (r Resource) getNotificationURL(notification Notification, namespace string) (string, error) {
+	secret, err := r.K8sClient.CoreV1().Secrets(namespace).Get(notification.URLSecret, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	key := notification.URLKey
+	if key == "" {
+		key = defaultNotificationURLKey
+	}
+	url := strings.TrimSpace(string(secret.Data[key]))
+	if url == "" {
+		return "", fmt.Errorf("secret %s has no %s", notification.URLSecret, key)
+	}
+	return url, nil
+}
+
+// sendNotifications sends the notifications a webhook has for a completed PipelineRun, failures are logged
+func (r Resource) sendNotifications(webhook Webhook, pipelineRun v1alpha1.PipelineRun) {
+	if len(webhook.Notifications) == 0 {
+		return
+	}
+	status, _, _ := runStatus(pipelineRun)
+	previousFailed := status == runSucceeded && r.previousRunFailed(webhook, summarizeRun(pipelineRun), pipelineRun.Namespace)
+	message := newRunNotification(webhook, pipelineRun, previousFailed)
+	for _, notification := range webhook.Notifications {
+		if !shouldNotify(notification, status, previousFailed) {
+			continue
+		}
+		url, err := r.getNotificationURL(notification, pipelineRun.Namespace)
+		if err != nil {
+			r.log().Errorf("could not get the URL of the %s notification, error: %s", notification.Format, err)
+			continue
+		}
+		if err := postJSON(url, formatNotification(notification.Format, message), nil); err != nil {
+			r.log().Errorf("could not send the %s notification, error: %s", notification.Format, err)
+			continue
+		}
+		r.log().Infof("Sent the %s notification that PipelineRun %s %s", notification.Format, pipelineRun.Name, status)
+	}
+}
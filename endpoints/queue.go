@@ -0,0 +1,234 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// QueueConfigMapName ... the name of the ConfigMap holding queued PipelineRuns
+const QueueConfigMapName = "githubwebhook-queue"
+
+const queueKey = "queue"
+
+// Events are no longer queued past this many, keeping the queue ConfigMap well under the 1MB object size limit
+const maxQueuedRuns = 100
+
+// Number of attempts at updating the queue ConfigMap when another replica updates it at the same time
+const queueUpdateAttempts = 5
+
+// Serializes queue passes within a replica, other replicas are handled through ConfigMap update conflicts
+var queueLock sync.Mutex
+
+// QueuedRun is an event waiting for a concurrency limit to allow its PipelineRun to start
+type QueuedRun struct {
+	Webhook          string           `json:"webhook"`
+	BuildInformation BuildInformation `json:"buildinformation"`
+	Queued           string           `json:"queued"`
+}
+
+// RunQueue describes the queue depth overall and per webhook
+type RunQueue struct {
+	Depth    int            `json:"depth"`
+	Webhooks map[string]int `json:"webhooks"`
+	Runs     []QueuedRun    `json:"runs"`
+}
+
+// Returns the limit on running PipelineRuns across all webhooks, set through MAX_CONCURRENT_RUNS. 0 means no limit
+func getGlobalMaxConcurrentRuns() int {
+	value := os.Getenv("MAX_CONCURRENT_RUNS")
+	if value == "" {
+		return 0
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Warnf("ignoring invalid MAX_CONCURRENT_RUNS value %s: %s", value, err)
+		return 0
+	}
+	return limit
+}
+
+// readRunQueue returns the queued runs in order along with the ConfigMap they were read from
+func (r Resource) readRunQueue(namespace string) ([]QueuedRun, *corev1.ConfigMap, error) {
+	configMap, err := r.K8sClient.CoreV1().ConfigMaps(namespace).Get(QueueConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return nil, nil, err
+		}
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      QueueConfigMapName,
+				Namespace: namespace,
+			},
+		}
+	}
+	if configMap.BinaryData == nil {
+		configMap.BinaryData = make(map[string][]byte)
+	}
+	queue := []QueuedRun{}
+	if raw, ok := configMap.BinaryData[queueKey]; ok {
+		if err := json.Unmarshal(raw, &queue); err != nil {
+			return nil, nil, err
+		}
+	}
+	return queue, configMap, nil
+}
+
+// writeRunQueue stores the queue in the ConfigMap it was read from, failing with a conflict if it changed since
+func (r Resource) writeRunQueue(configMap *corev1.ConfigMap, queue []QueuedRun) error {
+	buf, err := json.Marshal(queue)
+	if err != nil {
+		return err
+	}
+	configMap.BinaryData[queueKey] = buf
+	configMapClient := r.K8sClient.CoreV1().ConfigMaps(configMap.Namespace)
+	if configMap.ResourceVersion == "" {
+		_, err = configMapClient.Create(configMap)
+	} else {
+		_, err = configMapClient.Update(configMap)
+	}
+	return err
+}
+
+// enqueueRun adds an event to the end of the queue, the queue survives listener restarts as it is kept in a ConfigMap
+func (r Resource) enqueueRun(webhook Webhook, buildInformation BuildInformation, namespace string) error {
+	queued := QueuedRun{Webhook: webhook.Name, BuildInformation: buildInformation, Queued: getDateTimeAsString()}
+	var err error
+	for attempt := 0; attempt < queueUpdateAttempts; attempt++ {
+		var queue []QueuedRun
+		var configMap *corev1.ConfigMap
+		queue, configMap, err = r.readRunQueue(namespace)
+		if err != nil {
+			return err
+		}
+		if len(queue) >= maxQueuedRuns {
+			return fmt.Errorf("the PipelineRun queue is full with %d events", len(queue))
+		}
+		err = r.writeRunQueue(configMap, append(queue, queued))
+		if err == nil {
+			r.log().Infof("Queued PipelineRun for webhook %s, %d event(s) in the queue", webhook.Name, len(queue)+1)
+			return nil
+		}
+		if !k8serrors.IsConflict(err) && !k8serrors.IsAlreadyExists(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// countRunningPipelineRuns returns the number of unfinished PipelineRuns created by the listener, per webhook and in total
+func (r Resource) countRunningPipelineRuns(namespace string) (map[string]int, int, error) {
+	list, err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).List(metav1.ListOptions{LabelSelector: "app=devops-knative"})
+	if err != nil {
+		return nil, 0, err
+	}
+	running := make(map[string]int)
+	total := 0
+	for _, pipelineRun := range list.Items {
+		if isPipelineRunDone(pipelineRun) {
+			continue
+		}
+		running[pipelineRunWebhook(pipelineRun)]++
+		total++
+	}
+	return running, total, nil
+}
+
+/* Start queued PipelineRuns, in order, as far as the per webhook and global limits allow.
+Events for a webhook at its limit stay queued and keep their position */
+func (r Resource) processRunQueue(namespace string) {
+	queueLock.Lock()
+	defer queueLock.Unlock()
+	// Queued events fail on their own account, not on that of a dead letter being requeued
+	r.requeuedDeadLetter = ""
+
+	queue, configMap, err := r.readRunQueue(namespace)
+	if err != nil {
+		r.log().Errorf("could not read the PipelineRun queue, error: %s", err)
+		return
+	}
+	if len(queue) == 0 {
+		return
+	}
+	running, total, err := r.countRunningPipelineRuns(namespace)
+	if err != nil {
+		r.log().Errorf("could not count running PipelineRuns, error: %s", err)
+		return
+	}
+	webhooks := r.readGitHubWebhook(namespace)
+	globalLimit := getGlobalMaxConcurrentRuns()
+
+	remaining := []QueuedRun{}
+	toStart := []QueuedRun{}
+	blocked := make(map[string]bool)
+	for _, queued := range queue {
+		webhook, ok := webhooks[queued.Webhook]
+		if !ok {
+			r.log().Warnf("dropping queued event for webhook %s which no longer exists", queued.Webhook)
+			continue
+		}
+		atLimit := (globalLimit > 0 && total >= globalLimit) ||
+			(webhook.MaxConcurrentRuns > 0 && running[webhook.Name] >= webhook.MaxConcurrentRuns)
+		if blocked[webhook.Name] || atLimit {
+			blocked[webhook.Name] = true
+			remaining = append(remaining, queued)
+			continue
+		}
+		running[webhook.Name]++
+		total++
+		toStart = append(toStart, queued)
+	}
+	if len(remaining) == len(queue) {
+		return
+	}
+
+	// Only start runs once they are off the queue, if another replica got there first it will start them
+	if err := r.writeRunQueue(configMap, remaining); err != nil {
+		r.log().Errorf("could not update the PipelineRun queue, error: %s", err)
+		return
+	}
+	for _, queued := range toStart {
+		r.log().Infof("Starting queued PipelineRun for webhook %s, queued at %s", queued.Webhook, queued.Queued)
+		if _, err := r.withFields("webhook", queued.Webhook).createPipelineRunWithRetry(webhooks[queued.Webhook], queued.BuildInformation, namespace); err != nil {
+			r.log().Errorf("could not start queued PipelineRun for webhook %s, error: %s", queued.Webhook, err)
+		}
+	}
+}
+
+// ProcessRunQueue periodically starts queued PipelineRuns as earlier runs complete, it does not return
+func (r Resource) ProcessRunQueue(interval time.Duration) {
+	pipelineNs := getPipelineRunNamespace()
+	for range time.Tick(interval) {
+		r.processRunQueue(pipelineNs)
+	}
+}
+
+func (r Resource) getRunQueue(request *restful.Request, response *restful.Response) {
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	queue, _, err := r.readRunQueue(namespace)
+	if err != nil {
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	result := RunQueue{Depth: len(queue), Webhooks: make(map[string]int), Runs: queue}
+	for i, queued := range queue {
+		result.Webhooks[queued.Webhook]++
+		// Parameter overrides can carry credentials
+		result.Runs[i].BuildInformation = queued.BuildInformation.forLogging()
+	}
+	response.WriteEntity(result)
+}
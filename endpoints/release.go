@@ -0,0 +1,121 @@
+package endpoints
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	gh "gopkg.in/go-playground/webhooks.v3/github"
+)
+
+const tagRefPrefix = "refs/tags/"
+
+// Values for a webhook's release trigger, deciding whether tag pushes or published GitHub releases start the release pipeline
+const releaseTriggerTag = "tag"
+const releaseTriggerRelease = "release"
+
+// Why checkReleaseTrigger skips a tag or release
+const noReleasePipelineReason = "the webhook has no release pipeline"
+const releaseTriggerReason = "the webhook's release pipeline is started by"
+
+// Semantic versions with an optional leading v, e.g. v1.2.3-rc.1+build.5
+var semverPattern = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// Tags are cut to this length to serve as image tags and in release names, which have length limits of their own
+const maxShortTagLength = 40
+
+// shortID returns the first 7 characters of a commit SHA, or else a tag name made fit for an image tag. A tag too long
+// for that is cut and given a hash of the whole tag, so that tags with the same start don't overwrite each other's images
+func shortID(id string) string {
+	if len(id) == 40 && commitIDPattern.MatchString(id) {
+		return id[0:7]
+	}
+	tag := strings.ToLower(labelValue(id))
+	if len(tag) > maxShortTagLength {
+		hash := sha1.Sum([]byte(id))
+		tag = strings.Trim(tag[0:maxShortTagLength-9], "-_.") + "-" + hex.EncodeToString(hash[:])[0:8]
+	}
+	return tag
+}
+
+// checkReleaseTrigger returns why a tag or release should not start the webhook's release pipeline, or ""
+func checkReleaseTrigger(webhook Webhook, buildInformation BuildInformation) string {
+	if webhook.ReleasePipeline == "" {
+		return noReleasePipelineReason
+	}
+	trigger := webhook.ReleaseTrigger
+	if trigger == "" {
+		trigger = releaseTriggerTag
+	}
+	// Publishing a release also pushes its tag, only one of them starts the release pipeline
+	if (trigger == releaseTriggerTag) != (buildInformation.EVENTTYPE == "push") {
+		return fmt.Sprintf("%s %s events", releaseTriggerReason, trigger)
+	}
+	return ""
+}
+
+// releaseParams returns the tag, version and, for semantic versions, major, minor, patch and prerelease params
+func releaseParams(buildInformation BuildInformation) []v1alpha1.Param {
+	tag := buildInformation.TAG
+	params := []v1alpha1.Param{{Name: "tag", Value: tag}, {Name: "version", Value: strings.TrimPrefix(tag, "v")}}
+	prerelease := buildInformation.PRERELEASE
+	if parts := semverPattern.FindStringSubmatch(tag); parts != nil {
+		params = append(params,
+			v1alpha1.Param{Name: "major", Value: parts[1]},
+			v1alpha1.Param{Name: "minor", Value: parts[2]},
+			v1alpha1.Param{Name: "patch", Value: parts[3]})
+		prerelease = prerelease || parts[4] != ""
+	}
+	return append(params, v1alpha1.Param{Name: "prerelease", Value: strconv.FormatBool(prerelease)})
+}
+
+// handleRelease starts the release pipeline for a published GitHub release
+func (r Resource) handleRelease(request *restful.Request, response *restful.Response) {
+	webhookData := gh.ReleasePayload{}
+	if err := request.ReadEntity(&webhookData); err != nil {
+		r.log().Errorf("an error occurred decoding webhook data: %s", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	if err := validateReleasePayload(webhookData); err != nil {
+		r.log().Warnf("invalid release event: %s", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	if webhookData.Action != "published" {
+		r.respondSkipped(response, fmt.Sprintf("release action %s is not built", webhookData.Action))
+		return
+	}
+
+	r = r.withFields("repo", webhookData.Repository.HTMLURL)
+	// Release events don't carry the commit, the tag is enough to check out
+	tag := webhookData.Release.TagName
+	buildInformation := BuildInformation{
+		REPOURL:    webhookData.Repository.HTMLURL,
+		SHORTID:    shortID(tag),
+		COMMITID:   tag,
+		REPONAME:   webhookData.Repository.Name,
+		TIMESTAMP:  getDateTimeAsString(),
+		EVENTTYPE:  "release",
+		TAG:        tag,
+		PRERELEASE: webhookData.Release.Prerelease,
+		SENDER:     webhookData.Sender.Login,
+		DELIVERYID: getDeliveryID(request),
+	}
+	skipReason, err := createPipelineRunFromWebhookData(buildInformation, r)
+	if err != nil {
+		r.respondFailed(response, err)
+		return
+	}
+	if skipReason != "" {
+		r.respondSkipped(response, skipReason)
+		return
+	}
+	r.log().Debugf("Build information for release %s of %s %+v", tag, buildInformation.REPOURL, buildInformation.forLogging())
+}
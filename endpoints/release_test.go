@@ -0,0 +1,80 @@
+package endpoints
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReleaseParams(t *testing.T) {
+	tests := []struct {
+		tag        string
+		prerelease bool
+		want       map[string]string
+	}{
+		{"v1.2.3", false, map[string]string{"tag": "v1.2.3", "version": "1.2.3", "major": "1", "minor": "2", "patch": "3", "prerelease": "false"}},
+		{"1.0.0-rc.1+build.5", false, map[string]string{"version": "1.0.0-rc.1+build.5", "major": "1", "minor": "0", "patch": "0", "prerelease": "true"}},
+		{"v10.20.30", true, map[string]string{"major": "10", "minor": "20", "patch": "30", "prerelease": "true"}},
+		// Not semantic versions, only the tag and version are known
+		{"v1.2", false, map[string]string{"tag": "v1.2", "version": "1.2", "major": "", "prerelease": "false"}},
+		{"v01.2.3", false, map[string]string{"major": "", "prerelease": "false"}},
+		{"nightly", false, map[string]string{"tag": "nightly", "version": "nightly", "major": "", "prerelease": "false"}},
+	}
+	for _, test := range tests {
+		params := map[string]string{}
+		for _, param := range releaseParams(BuildInformation{TAG: test.tag, PRERELEASE: test.prerelease}) {
+			params[param.Name] = param.Value
+		}
+		for name, want := range test.want {
+			if got := params[name]; got != want {
+				t.Errorf("%s: got param %s = %q, want %q", test.tag, name, got, want)
+			}
+		}
+	}
+}
+
+func TestCheckReleaseTrigger(t *testing.T) {
+	tests := []struct {
+		name      string
+		pipeline  string
+		trigger   string
+		eventType string
+		started   bool
+	}{
+		{"no release pipeline", "", "", "push", false},
+		{"tag push by default", "release", "", "push", true},
+		{"release ignored by default", "release", "", "release", false},
+		{"release trigger", "release", releaseTriggerRelease, "release", true},
+		{"tag push ignored by release trigger", "release", releaseTriggerRelease, "push", false},
+	}
+	for _, test := range tests {
+		webhook := Webhook{ReleasePipeline: test.pipeline, ReleaseTrigger: test.trigger}
+		reason := checkReleaseTrigger(webhook, BuildInformation{TAG: "v1.0.0", EVENTTYPE: test.eventType})
+		if started := reason == ""; started != test.started {
+			t.Errorf("%s: got reason %q, want started %t", test.name, reason, test.started)
+		}
+	}
+}
+
+func TestShortID(t *testing.T) {
+	tests := []struct {
+		id, want string
+	}{
+		{testCommitID, "0123456"},
+		{"v1.0", "v1.0"},
+		{"V1", "v1"},
+		{"v1.10.10", "v1.10.10"},
+		{"release/2019-05", "release-2019-05"},
+	}
+	for _, test := range tests {
+		if got := shortID(test.id); got != test.want {
+			t.Errorf("shortID(%q) = %q, want %q", test.id, got, test.want)
+		}
+	}
+
+	// Long tags that only differ past the cut still get different IDs
+	first := shortID("v1.0.0-" + strings.Repeat("a", 40) + "-first")
+	second := shortID("v1.0.0-" + strings.Repeat("a", 40) + "-second")
+	if len(first) > maxShortTagLength || len(second) > maxShortTagLength || first == second {
+		t.Errorf("got short IDs %q and %q for long tags, want different IDs of at most %d characters", first, second, maxShortTagLength)
+	}
+}
@@ -0,0 +1,268 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DeadLetterConfigMapName ... the name of the ConfigMap holding events whose PipelineRun could not be created
+const DeadLetterConfigMapName = "githubwebhook-deadletter"
+
+const deadLetterKey = "deadletters"
+
+// The oldest dead letters are dropped past this many
+const maxDeadLetters = 100
+
+// Creating a PipelineRun is attempted 4 times over about 3.5 seconds, short enough for the sender not to give up on the event
+var createBackoff = wait.Backoff{Duration: 500 * time.Millisecond, Factor: 2, Jitter: 0.1, Steps: 4}
+
+// Reasons of the Kubernetes Events recorded on webhooks
+const retryingPipelineRunReason = "RetryingPipelineRun"
+const pipelineRunFailedReason = "PipelineRunFailed"
+
+// DeadLetter is an event whose PipelineRun still could not be created after retrying
+type DeadLetter struct {
+	ID               string           `json:"id"`
+	Webhook          string           `json:"webhook"`
+	BuildInformation BuildInformation `json:"buildinformation"`
+	Error            string           `json:"error"`
+	Attempts         int              `json:"attempts"`
+	Failed           string           `json:"failed"`
+}
+
+// isTransientError returns true for errors that retrying may get past, such as API throttling or timeouts
+func isTransientError(err error) bool {
+	if creationErr, ok := err.(creationError); ok {
+		err = creationErr.cause
+	}
+	if k8serrors.IsServerTimeout(err) || k8serrors.IsTimeout(err) || k8serrors.IsTooManyRequests(err) ||
+		k8serrors.IsInternalError(err) || k8serrors.IsServiceUnavailable(err) || k8serrors.IsUnexpectedServerError(err) {
+		return true
+	}
+	// No response from the API server at all, e.g. a connection reset
+	_, isNetError := err.(net.Error)
+	return isNetError
+}
+
+/* Create the PipelineRun for a webhook event, retrying transient errors with exponential backoff.
+Every attempt uses the same names, so a PipelineRun an attempt created despite failing is found rather than created twice.
+Events that still fail are dead lettered, every retry and the final failure are recorded as Kubernetes Events on the webhook */
+func (r Resource) createPipelineRunWithRetry(webhook Webhook, buildInformation BuildInformation, pipelineNs string) (string, error) {
+	var pipelineRunName string
+	var lastErr error
+	attempts := 0
+	suffix := newRunSuffix()
+	err := wait.ExponentialBackoff(createBackoff, func() (bool, error) {
+		attempts++
+		attempt, span := r.startSpan("createPipelineRunAttempt", buildAttributes(webhook, buildInformation))
+		span.SetAttributes(attribute.Int("attempt", attempts))
+		var err error
+		pipelineRunName, err = attempt.createPipelineRun(webhook, buildInformation, pipelineNs, suffix)
+		endSpan(span, err)
+		recordPipelineRunCreation(webhook, err)
+		if err == nil {
+			return true, nil
+		}
+		lastErr = err
+		if !isTransientError(err) {
+			return false, err
+		}
+		if attempts < createBackoff.Steps {
+			r.log().Infof("attempt %d at creating the PipelineRun for webhook %s failed, retrying: %s", attempts, webhook.Name, err)
+			r.recordWebhookEvent(webhook, corev1.EventTypeWarning, retryingPipelineRunReason,
+				fmt.Sprintf("Attempt %d at creating the PipelineRun for %s failed, retrying: %s", attempts, buildInformation.COMMITID, err))
+		}
+		return false, nil
+	})
+	if err == nil {
+		return pipelineRunName, nil
+	}
+	if err == wait.ErrWaitTimeout {
+		err = lastErr
+	}
+
+	r.log().Errorf("could not create the PipelineRun for webhook %s after %d attempt(s): %s", webhook.Name, attempts, err)
+	r.recordWebhookEvent(webhook, corev1.EventTypeWarning, pipelineRunFailedReason,
+		fmt.Sprintf("Could not create the PipelineRun for %s after %d attempt(s), the event is dead lettered: %s", buildInformation.COMMITID, attempts, err))
+	if deadLetterErr := r.addDeadLetter(webhook, buildInformation, err, attempts, pipelineNs); deadLetterErr != nil {
+		r.log().Errorf("could not dead letter the event for webhook %s, it is lost: %s", webhook.Name, deadLetterErr)
+	}
+	return "", err
+}
+
+// readDeadLetters returns the dead letters, oldest first, along with the ConfigMap they were read from
+func (r Resource) readDeadLetters(namespace string) ([]DeadLetter, *corev1.ConfigMap, error) {
+	configMap, err := r.K8sClient.CoreV1().ConfigMaps(namespace).Get(DeadLetterConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return nil, nil, err
+		}
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      DeadLetterConfigMapName,
+				Namespace: namespace,
+			},
+		}
+	}
+	if configMap.BinaryData == nil {
+		configMap.BinaryData = make(map[string][]byte)
+	}
+	deadLetters := []DeadLetter{}
+	if raw, ok := configMap.BinaryData[deadLetterKey]; ok {
+		if err := json.Unmarshal(raw, &deadLetters); err != nil {
+			return nil, nil, err
+		}
+	}
+	return deadLetters, configMap, nil
+}
+
+// writeDeadLetters stores the dead letters in the ConfigMap they were read from, failing with a conflict if it changed since
+func (r Resource) writeDeadLetters(configMap *corev1.ConfigMap, deadLetters []DeadLetter) error {
+	buf, err := json.Marshal(deadLetters)
+	if err != nil {
+		return err
+	}
+	configMap.BinaryData[deadLetterKey] = buf
+	configMapClient := r.K8sClient.CoreV1().ConfigMaps(configMap.Namespace)
+	if configMap.ResourceVersion == "" {
+		_, err = configMapClient.Create(configMap)
+	} else {
+		_, err = configMapClient.Update(configMap)
+	}
+	return err
+}
+
+// updateDeadLetters applies a change to the dead letters, trying again when another replica updates them at the same time
+func (r Resource) updateDeadLetters(namespace string, update func([]DeadLetter) ([]DeadLetter, error)) error {
+	var err error
+	for attempt := 0; attempt < queueUpdateAttempts; attempt++ {
+		var deadLetters []DeadLetter
+		var configMap *corev1.ConfigMap
+		deadLetters, configMap, err = r.readDeadLetters(namespace)
+		if err != nil {
+			return err
+		}
+		deadLetters, err = update(deadLetters)
+		if err != nil {
+			return err
+		}
+		err = r.writeDeadLetters(configMap, deadLetters)
+		if err == nil || (!k8serrors.IsConflict(err) && !k8serrors.IsAlreadyExists(err)) {
+			return err
+		}
+	}
+	return err
+}
+
+// addDeadLetter dead letters an event, or when it comes from requeueing a dead letter, records the new failure on that
+func (r Resource) addDeadLetter(webhook Webhook, buildInformation BuildInformation, cause error, attempts int, namespace string) error {
+	now := time.Now().UTC()
+	deadLetter := DeadLetter{
+		ID:               fmt.Sprintf("%s-%d", webhook.Name, now.UnixNano()),
+		Webhook:          webhook.Name,
+		BuildInformation: buildInformation,
+		Error:            cause.Error(),
+		Attempts:         attempts,
+		Failed:           now.Format(time.RFC3339),
+	}
+	return r.updateDeadLetters(namespace, func(deadLetters []DeadLetter) ([]DeadLetter, error) {
+		for i := range deadLetters {
+			if r.requeuedDeadLetter != "" && deadLetters[i].ID == r.requeuedDeadLetter {
+				deadLetters[i].Error = deadLetter.Error
+				deadLetters[i].Attempts += attempts
+				deadLetters[i].Failed = deadLetter.Failed
+				return deadLetters, nil
+			}
+		}
+		deadLetters = append(deadLetters, deadLetter)
+		if len(deadLetters) > maxDeadLetters {
+			deadLetters = deadLetters[len(deadLetters)-maxDeadLetters:]
+		}
+		return deadLetters, nil
+	})
+}
+
+func (r Resource) getDeadLetters(request *restful.Request, response *restful.Response) {
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	deadLetters, _, err := r.readDeadLetters(namespace)
+	if err != nil {
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	// Parameter overrides can carry credentials
+	for i := range deadLetters {
+		deadLetters[i].BuildInformation = deadLetters[i].BuildInformation.forLogging()
+	}
+	response.WriteEntity(deadLetters)
+}
+
+// requeueDeadLetter starts the PipelineRun of a dead lettered event again, taking the event off the dead letters
+// once the PipelineRun is created or queued
+func (r Resource) requeueDeadLetter(request *restful.Request, response *restful.Response) {
+	id := request.PathParameter("id")
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	deadLetters, _, err := r.readDeadLetters(namespace)
+	if err != nil {
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	var requeued DeadLetter
+	for _, deadLetter := range deadLetters {
+		if deadLetter.ID == id {
+			requeued = deadLetter
+		}
+	}
+	if requeued.ID == "" {
+		RespondErrorMessage(response, fmt.Sprintf("no dead letter %s", id), http.StatusNotFound)
+		return
+	}
+	webhook, ok := r.readGitHubWebhook(namespace)[requeued.Webhook]
+	if !ok {
+		RespondErrorMessage(response, fmt.Sprintf("webhook %s no longer exists", requeued.Webhook), http.StatusNotFound)
+		return
+	}
+
+	r.log().Infof("Requeueing dead letter %s for webhook %s", id, requeued.Webhook)
+	requeuing := r.withFields("webhook", webhook.Name)
+	requeuing.requeuedDeadLetter = id
+	pipelineRunName, err := requeuing.startPipelineRun(webhook, requeued.BuildInformation, getPipelineRunNamespace())
+	if err != nil {
+		RespondErrorAndMessage(response, err, fmt.Sprintf("could not start the PipelineRun for dead letter %s, it stays dead lettered: %s", id, err), http.StatusInternalServerError)
+		return
+	}
+	err = r.updateDeadLetters(namespace, func(deadLetters []DeadLetter) ([]DeadLetter, error) {
+		for i, deadLetter := range deadLetters {
+			if deadLetter.ID == id {
+				return append(deadLetters[:i:i], deadLetters[i+1:]...), nil
+			}
+		}
+		return deadLetters, nil
+	})
+	if err != nil {
+		r.log().Errorf("started the PipelineRun for dead letter %s but could not take it off the dead letters, error: %s", id, err)
+	}
+	if pipelineRunName == "" {
+		response.WriteHeaderAndJson(http.StatusAccepted, TriggerResponse{Queued: true}, restful.MIME_JSON)
+		return
+	}
+	response.WriteHeaderAndJson(http.StatusCreated, TriggerResponse{PipelineRun: pipelineRunName}, restful.MIME_JSON)
+}
@@ -0,0 +1,147 @@
+package endpoints
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	eventapi "github.com/knative/eventing-sources/pkg/apis/sources/v1alpha1"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Types of the CloudEvents the listener emits about the PipelineRuns it creates
+const (
+	runStartedEventType   = "dev.tekton.webhook.run.started"
+	runSucceededEventType = "dev.tekton.webhook.run.succeeded"
+	runFailedEventType    = "dev.tekton.webhook.run.failed"
+)
+
+// ObjectReference names a Kubernetes object in CloudEvents data
+type ObjectReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	UID        string `json:"uid,omitempty"`
+}
+
+// RunEventDelivery is the event that led to the PipelineRun
+type RunEventDelivery struct {
+	ID     string `json:"id,omitempty"`
+	Event  string `json:"event,omitempty"`
+	Sender string `json:"sender,omitempty"`
+}
+
+// RunOutcome is how a PipelineRun ended
+type RunOutcome struct {
+	Status     string `json:"status"`
+	Reason     string `json:"reason,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Start      string `json:"start,omitempty"`
+	Completion string `json:"completion,omitempty"`
+	Duration   int64  `json:"duration,omitempty"`
+}
+
+// RunEvent is the data of the CloudEvents the listener emits
+type RunEvent struct {
+	Delivery    RunEventDelivery `json:"delivery"`
+	Webhook     ObjectReference  `json:"webhook"`
+	PipelineRun ObjectReference  `json:"pipelineRun"`
+	Repository  string           `json:"repository"`
+	Commit      string           `json:"commit,omitempty"`
+	Branch      string           `json:"branch,omitempty"`
+	Tag         string           `json:"tag,omitempty"`
+	PullRequest string           `json:"pullRequest,omitempty"`
+	Trigger     string           `json:"trigger,omitempty"`
+	Outcome     *RunOutcome      `json:"outcome,omitempty"`
+}
+
+// Returns where to send CloudEvents: EVENT_SINK, else the K_SINK a Knative SinkBinding sets, or "" to send none
+func getEventSink() string {
+	if sink := os.Getenv("EVENT_SINK"); sink != "" {
+		return sink
+	}
+	return os.Getenv("K_SINK")
+}
+
+// newRunEvent describes a webhook's PipelineRun, with its outcome once it has completed
+func newRunEvent(webhook Webhook, pipelineRun v1alpha1.PipelineRun) RunEvent {
+	run := summarizeRun(pipelineRun)
+	event := RunEvent{
+		Delivery: RunEventDelivery{
+			ID:     pipelineRun.Annotations[deliveryIDAnnotation],
+			Event:  run.EventType,
+			Sender: run.Sender,
+		},
+		Webhook: ObjectReference{
+			APIVersion: eventapi.SchemeGroupVersion.String(),
+			Kind:       "GitHubSource",
+			Name:       webhook.Name,
+			Namespace:  webhook.Namespace,
+		},
+		PipelineRun: ObjectReference{
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			Kind:       "PipelineRun",
+			Name:       pipelineRun.Name,
+			Namespace:  pipelineRun.Namespace,
+			UID:        string(pipelineRun.UID),
+		},
+		Repository:  webhook.GitRepositoryURL,
+		Commit:      run.Commit,
+		Branch:      run.Branch,
+		Tag:         run.Tag,
+		PullRequest: run.PullRequest,
+		Trigger:     run.Trigger,
+	}
+	if isPipelineRunDone(pipelineRun) {
+		event.Outcome = &RunOutcome{
+			Status:     run.Status,
+			Reason:     run.Reason,
+			Message:    run.Message,
+			Start:      run.Start,
+			Completion: run.Completion,
+			Duration:   run.Duration,
+		}
+	}
+	return event
+}
+
+/* emitRunEvent sends a CloudEvent about a PipelineRun to the configured sink in binary mode, failures are logged.
+Events have the ID of the PipelineRun and their type, so a receiver can tell repeats apart */
+func (r Resource) emitRunEvent(eventType string, webhook Webhook, pipelineRun v1alpha1.PipelineRun) {
+	sink := getEventSink()
+	if sink == "" {
+		return
+	}
+	headers := map[string]string{
+		"Ce-Specversion": "1.0",
+		"Ce-Type":        eventType,
+		"Ce-Source":      fmt.Sprintf("/webhooks-extension/namespaces/%s/webhooks/%s", webhook.Namespace, webhook.Name),
+		"Ce-Id":          fmt.Sprintf("%s/%s.%s", pipelineRun.Namespace, pipelineRun.Name, eventType),
+		"Ce-Subject":     pipelineRun.Name,
+		"Ce-Time":        time.Now().UTC().Format(time.RFC3339),
+	}
+	// Carry the trace of the event being handled on, as the distributed tracing extension
+	if r.ctx != nil {
+		carrier := propagation.MapCarrier{}
+		otel.GetTextMapPropagator().Inject(r.ctx, carrier)
+		if traceparent := carrier.Get("traceparent"); traceparent != "" {
+			headers[cloudEventHeaderPrefix+"Traceparent"] = traceparent
+		}
+	}
+	if err := postJSON(sink, newRunEvent(webhook, pipelineRun), headers); err != nil {
+		r.log().Errorf("could not send the %s event for PipelineRun %s, error: %s", eventType, pipelineRun.Name, err)
+		return
+	}
+	r.log().Infof("Sent the %s event for PipelineRun %s", eventType, pipelineRun.Name)
+}
+
+// Returns the type of the CloudEvent for a completed PipelineRun, cancelled runs count as failed
+func completedEventType(pipelineRun v1alpha1.PipelineRun) string {
+	if status, _, _ := runStatus(pipelineRun); status == runSucceeded {
+		return runSucceededEventType
+	}
+	return runFailedEventType
+}
@@ -0,0 +1,248 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	duckv1alpha1 "github.com/knative/pkg/apis/duck/v1alpha1"
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Statuses of a PipelineRun as the runs API reports them
+const (
+	runPending   = "pending"
+	runRunning   = "running"
+	runSucceeded = "succeeded"
+	runFailed    = "failed"
+	runCancelled = "cancelled"
+)
+
+// Length of a full commit SHA
+const fullCommitIDLength = 40
+
+// Page size of the runs API when none is asked for, and the most it returns at once
+const defaultRunsPageSize = 20
+const maxRunsPageSize = 100
+
+// RunSummary describes a PipelineRun a webhook created
+type RunSummary struct {
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Reason      string `json:"reason,omitempty"`
+	Message     string `json:"message,omitempty"`
+	EventType   string `json:"eventtype,omitempty"`
+	Trigger     string `json:"trigger,omitempty"`
+	TriggeredBy string `json:"triggeredby,omitempty"`
+	Sender      string `json:"sender,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	Tag         string `json:"tag,omitempty"`
+	PullRequest string `json:"pullrequest,omitempty"`
+	Commit      string `json:"commit,omitempty"`
+	Created     string `json:"created"`
+	Start       string `json:"start,omitempty"`
+	Completion  string `json:"completion,omitempty"`
+	// Duration is how long a completed run took, in seconds
+	Duration int64 `json:"duration,omitempty"`
+}
+
+// RunList is a page of a webhook's PipelineRuns, most recent first
+type RunList struct {
+	Runs  []RunSummary `json:"runs"`
+	Total int          `json:"total"`
+	Page  int          `json:"page"`
+	Limit int          `json:"limit"`
+}
+
+// runStatus returns the status of a PipelineRun and the reason and message of its condition
+func runStatus(pipelineRun v1alpha1.PipelineRun) (string, string, string) {
+	condition := pipelineRun.Status.GetCondition(duckv1alpha1.ConditionSucceeded)
+	if pipelineRun.Spec.Status == v1alpha1.PipelineRunSpecStatusCancelled && (condition == nil || condition.Status != corev1.ConditionTrue) {
+		if condition == nil {
+			return runCancelled, "", ""
+		}
+		return runCancelled, condition.Reason, condition.Message
+	}
+	if condition == nil {
+		if pipelineRun.Status.StartTime == nil {
+			return runPending, "", ""
+		}
+		return runRunning, "", ""
+	}
+	switch condition.Status {
+	case corev1.ConditionTrue:
+		return runSucceeded, condition.Reason, condition.Message
+	case corev1.ConditionFalse:
+		return runFailed, condition.Reason, condition.Message
+	}
+	return runRunning, condition.Reason, condition.Message
+}
+
+// summarizeRun returns what the runs API reports about a PipelineRun, from its status and the listener's labels
+// and annotations. Annotations are preferred as labels may have been cut down to fit
+func summarizeRun(pipelineRun v1alpha1.PipelineRun) RunSummary {
+	status, reason, message := runStatus(pipelineRun)
+	valueOf := func(annotation, label string) string {
+		if value := pipelineRun.Annotations[annotation]; value != "" {
+			return value
+		}
+		return pipelineRun.Labels[label]
+	}
+	summary := RunSummary{
+		Name:        pipelineRun.Name,
+		Status:      status,
+		Reason:      reason,
+		Message:     message,
+		EventType:   valueOf(eventTypeAnnotation, eventTypeLabel),
+		Trigger:     pipelineRun.Labels[triggerLabel],
+		TriggeredBy: pipelineRun.Annotations[triggeredByAnnotation],
+		Sender:      valueOf(senderAnnotation, gitSenderLabel),
+		Branch:      valueOf(branchAnnotation, gitBranchLabel),
+		Tag:         valueOf(tagAnnotation, gitTagLabel),
+		PullRequest: valueOf(pullRequestAnnotation, gitPullRequestLabel),
+		Commit:      valueOf(commitAnnotation, gitCommitLabel),
+		Created:     pipelineRun.CreationTimestamp.UTC().Format(time.RFC3339),
+	}
+	if start := pipelineRun.Status.StartTime; start != nil {
+		summary.Start = start.UTC().Format(time.RFC3339)
+		if completion := pipelineRun.Status.CompletionTime; completion != nil {
+			summary.Completion = completion.UTC().Format(time.RFC3339)
+			summary.Duration = int64(completion.Sub(start.Time) / time.Second)
+		}
+	}
+	return summary
+}
+
+// runsSelector selects the PipelineRuns of a webhook, narrowed to a branch, pull request or commit when given.
+// Label values may be cut down, so callers check the results against the full values
+func runsSelector(name, branch, pullRequest, commit string) string {
+	selector := fmt.Sprintf("app=devops-knative,%s=%s", webhookLabel, labelValue(name))
+	if branch != "" {
+		selector = fmt.Sprintf("%s,%s=%s", selector, gitBranchLabel, labelValue(branch))
+	}
+	if pullRequest != "" {
+		selector = fmt.Sprintf("%s,%s=%s", selector, gitPullRequestLabel, labelValue(pullRequest))
+	}
+	// A full SHA fits a label, a short one is matched by prefix below
+	if len(commit) == fullCommitIDLength {
+		selector = fmt.Sprintf("%s,%s=%s", selector, gitCommitLabel, labelValue(commit))
+	}
+	return selector
+}
+
+/* listWebhookRuns returns the summaries of a webhook's PipelineRuns matching the filters, most recent first.
+Empty filters match everything, the commit matches by prefix so that short SHAs can be given */
+func (r Resource) listWebhookRuns(name, namespace, branch, pullRequest, commit, status string) ([]RunSummary, error) {
+	selector := runsSelector(name, branch, pullRequest, commit)
+	list, err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[j].CreationTimestamp.Before(&list.Items[i].CreationTimestamp)
+	})
+	runs := []RunSummary{}
+	for _, pipelineRun := range list.Items {
+		if pipelineRunWebhook(pipelineRun) != name {
+			continue
+		}
+		summary := summarizeRun(pipelineRun)
+		if (branch != "" && summary.Branch != branch) ||
+			(pullRequest != "" && summary.PullRequest != pullRequest) ||
+			(commit != "" && !strings.HasPrefix(summary.Commit, commit)) ||
+			(status != "" && summary.Status != status) {
+			continue
+		}
+		runs = append(runs, summary)
+	}
+	return runs, nil
+}
+
+// Returns the positive integer query parameter, its default when not given, or an error
+func positiveQueryParameter(request *restful.Request, name string, defaultValue int) (int, error) {
+	value := request.QueryParameter(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		return 0, fmt.Errorf("%s must be a positive number, but %s was given", name, value)
+	}
+	return number, nil
+}
+
+// getWebhookRuns lists a page of a webhook's PipelineRuns, filtered by the branch, pullrequest, commit and status query parameters
+func (r Resource) getWebhookRuns(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	status := request.QueryParameter("status")
+	switch status {
+	case "", runPending, runRunning, runSucceeded, runFailed, runCancelled:
+	default:
+		RespondErrorMessage(response, fmt.Sprintf("status must be one of %s, %s, %s, %s or %s, but %s was given",
+			runPending, runRunning, runSucceeded, runFailed, runCancelled, status), http.StatusBadRequest)
+		return
+	}
+	page, err := positiveQueryParameter(request, "page", 1)
+	if err != nil {
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	limit, err := positiveQueryParameter(request, "limit", defaultRunsPageSize)
+	if err != nil {
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	if limit > maxRunsPageSize {
+		limit = maxRunsPageSize
+	}
+
+	runs, err := r.listWebhookRuns(name, namespace, request.QueryParameter("branch"), request.QueryParameter("pullrequest"),
+		request.QueryParameter("commit"), status)
+	if err != nil {
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	result := RunList{Runs: []RunSummary{}, Total: len(runs), Page: page, Limit: limit}
+	if start := (page - 1) * limit; start < len(runs) {
+		end := start + limit
+		if end > len(runs) {
+			end = len(runs)
+		}
+		result.Runs = runs[start:end]
+	}
+	response.WriteEntity(result)
+}
+
+// getWebhookRun describes one of a webhook's PipelineRuns
+func (r Resource) getWebhookRun(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	runName := request.PathParameter("run")
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	pipelineRun, err := r.TektonClient.TektonV1alpha1().PipelineRuns(namespace).Get(runName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	if err != nil || pipelineRunWebhook(*pipelineRun) != name {
+		RespondErrorMessage(response, fmt.Sprintf("no PipelineRun %s for webhook %s", runName, name), http.StatusNotFound)
+		return
+	}
+	response.WriteEntity(summarizeRun(*pipelineRun))
+}
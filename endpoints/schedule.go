@@ -0,0 +1,222 @@
+package endpoints
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ScheduleConfigMapName ... the name of the ConfigMap recording when each scheduled webhook last ran
+const ScheduleConfigMapName = "githubwebhook-schedule"
+
+// Value of the trigger label and event type of PipelineRuns started by a webhook's schedule
+const scheduleTrigger = "schedule"
+
+// Branch scheduled runs build when the webhook doesn't name one
+const defaultScheduleBranch = "master"
+
+// Shorthands for common schedules
+var scheduleMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@nightly":  "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule is a parsed five field cron expression, each field a bitset of the values it matches
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// When both day fields are restricted a time matches either of them, as in cron
+	anyDay, anyWeekday bool
+}
+
+// parseSchedule parses a cron expression of minute, hour, day of month, month and day of week, e.g. 0 2 * * 1-5,
+// or one of the @daily style shorthands
+func parseSchedule(spec string) (cronSchedule, error) {
+	if macro, ok := scheduleMacros[strings.TrimSpace(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("schedule %q must have 5 fields: minute hour day-of-month month day-of-week", spec)
+	}
+	var schedule cronSchedule
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return cronSchedule{}, err
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return cronSchedule{}, err
+	}
+	if schedule.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return cronSchedule{}, err
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return cronSchedule{}, err
+	}
+	if schedule.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return cronSchedule{}, err
+	}
+	// Sunday is either 0 or 7
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+	// As in cron, a day field starting with * such as */2 counts as unrestricted
+	schedule.anyDay = strings.HasPrefix(fields[2], "*")
+	schedule.anyWeekday = strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of *, values, ranges and steps such as */15 or 1-5/2
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in schedule field %q", field)
+			}
+			rangePart = part[:i]
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in schedule field %q", field)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid range in schedule field %q", field)
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("schedule field %q is out of the range %d-%d", field, min, max)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// matches returns true if the schedule fires in the minute of the given time
+func (s cronSchedule) matches(t time.Time) bool {
+	if s.minutes&(1<<uint(t.Minute())) == 0 || s.hours&(1<<uint(t.Hour())) == 0 || s.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dayMatches := s.days&(1<<uint(t.Day())) != 0
+	weekdayMatches := s.weekdays&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeekday {
+		return dayMatches && weekdayMatches
+	}
+	return dayMatches || weekdayMatches
+}
+
+/* Record that the webhooks ran in this minute, returning the ones no other replica has already run.
+The ConfigMap update fails with a conflict if another replica got there first, in which case it runs them */
+func (r Resource) claimScheduledRuns(namespace string, due []Webhook, minute string) ([]Webhook, error) {
+	configMapClient := r.K8sClient.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMapClient.Get(ScheduleConfigMapName, metav1.GetOptions{})
+	exists := err == nil
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			return nil, err
+		}
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ScheduleConfigMapName,
+				Namespace: namespace,
+			},
+		}
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	claimed := []Webhook{}
+	for _, webhook := range due {
+		if configMap.Data[webhook.Name] != minute {
+			configMap.Data[webhook.Name] = minute
+			claimed = append(claimed, webhook)
+		}
+	}
+	if len(claimed) == 0 {
+		return claimed, nil
+	}
+	if !exists {
+		_, err = configMapClient.Create(configMap)
+	} else {
+		_, err = configMapClient.Update(configMap)
+	}
+	if k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err) {
+		return []Webhook{}, nil
+	}
+	return claimed, err
+}
+
+// runScheduledWebhooks starts the PipelineRuns of the webhooks whose schedule fires in the minute of now
+func (r Resource) runScheduledWebhooks(namespace string, now time.Time) {
+	due := []Webhook{}
+	for _, webhook := range r.readGitHubWebhook(namespace) {
+		if webhook.Schedule == "" {
+			continue
+		}
+		schedule, err := parseSchedule(webhook.Schedule)
+		if err != nil {
+			r.log().Warnf("ignoring the invalid schedule of webhook %s: %s", webhook.Name, err)
+			continue
+		}
+		if schedule.matches(now) {
+			due = append(due, webhook)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+	claimed, err := r.claimScheduledRuns(namespace, due, now.Format("2006-01-02T15:04Z"))
+	if err != nil {
+		r.log().Errorf("could not record the scheduled runs, error: %s", err)
+		return
+	}
+	for _, webhook := range claimed {
+		branch := webhook.ScheduleBranch
+		if branch == "" {
+			branch = defaultScheduleBranch
+		}
+		buildInformation, err := r.buildInformationForTrigger(webhook, TriggerRequest{Ref: branch})
+		if err != nil {
+			r.log().Errorf("could not resolve branch %s for the scheduled run of webhook %s: %s", branch, webhook.Name, err)
+			continue
+		}
+		buildInformation.EVENTTYPE = scheduleTrigger
+		buildInformation.TRIGGER = scheduleTrigger
+		r.log().Infof("Starting the scheduled run of webhook %s for %s at %s", webhook.Name, branch, buildInformation.COMMITID)
+		if _, err := r.withFields("webhook", webhook.Name).startPipelineRun(webhook, buildInformation, namespace); err != nil {
+			r.log().Errorf("could not start the scheduled PipelineRun for webhook %s, error: %s", webhook.Name, err)
+		}
+	}
+}
+
+// RunSchedules starts the PipelineRuns of webhooks with a schedule when it fires, in UTC. It does not return.
+// The interval must be under a minute so that no minute is missed
+func (r Resource) RunSchedules(interval time.Duration) {
+	pipelineNs := getPipelineRunNamespace()
+	for now := range time.Tick(interval) {
+		r.runScheduledWebhooks(pipelineNs, now.UTC().Truncate(time.Minute))
+	}
+}
@@ -0,0 +1,159 @@
+package endpoints
+
+import (
+	"testing"
+	"time"
+
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseCronField(t *testing.T) {
+	bits := func(values ...uint) uint64 {
+		var b uint64
+		for _, value := range values {
+			b |= 1 << value
+		}
+		return b
+	}
+	tests := []struct {
+		field    string
+		min, max int
+		want     uint64
+		valid    bool
+	}{
+		{"*", 0, 5, bits(0, 1, 2, 3, 4, 5), true},
+		{"3", 0, 59, bits(3), true},
+		{"1,3,5", 0, 59, bits(1, 3, 5), true},
+		{"1-4", 0, 59, bits(1, 2, 3, 4), true},
+		{"*/15", 0, 59, bits(0, 15, 30, 45), true},
+		{"10-20/5", 0, 59, bits(10, 15, 20), true},
+		// A value with a step runs from the value to the end of the range
+		{"50/3", 0, 59, bits(50, 53, 56, 59), true},
+		{"1-3,10", 0, 59, bits(1, 2, 3, 10), true},
+		{"0", 1, 31, 0, false},
+		{"32", 1, 31, 0, false},
+		{"5-1", 0, 59, 0, false},
+		{"*/0", 0, 59, 0, false},
+		{"*/x", 0, 59, 0, false},
+		{"a", 0, 59, 0, false},
+		{"1-", 0, 59, 0, false},
+		{"-1", 0, 59, 0, false},
+		{"1,,2", 0, 59, 0, false},
+		{"", 0, 59, 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseCronField(test.field, test.min, test.max)
+		if (err == nil) != test.valid {
+			t.Errorf("parseCronField(%q) got error %v, want valid %t", test.field, err, test.valid)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseCronField(%q) = %b, want %b", test.field, got, test.want)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec  string
+		valid bool
+	}{
+		{"0 2 * * *", true},
+		{"*/15 9-17 * * 1-5", true},
+		{"0 0 1,15 * 0", true},
+		{"0 0 * * 7", true},
+		{"  @daily  ", true},
+		{"@hourly", true},
+		{"@every 5m", false},
+		{"0 2 * *", false},
+		{"0 2 * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if _, err := parseSchedule(test.spec); (err == nil) != test.valid {
+			t.Errorf("parseSchedule(%q) got error %v, want valid %t", test.spec, err, test.valid)
+		}
+	}
+}
+
+func TestScheduleMatches(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	// 2019-06-03 is a Monday
+	tests := []struct {
+		spec string
+		time string
+		want bool
+	}{
+		{"0 2 * * *", "2019-06-03 02:00", true},
+		{"0 2 * * *", "2019-06-03 02:01", false},
+		{"0 2 * * *", "2019-06-03 03:00", false},
+		{"*/15 * * * *", "2019-06-03 10:45", true},
+		{"*/15 * * * *", "2019-06-03 10:46", false},
+		{"0 9-17 * * 1-5", "2019-06-03 17:00", true},
+		{"0 9-17 * * 1-5", "2019-06-08 12:00", false},
+		{"0 0 * * 0", "2019-06-09 00:00", true},
+		{"0 0 * * 7", "2019-06-09 00:00", true},
+		{"0 0 * * 7", "2019-06-08 00:00", false},
+		{"0 0 1 * *", "2019-07-01 00:00", true},
+		{"0 0 1 * *", "2019-07-02 00:00", false},
+		{"0 0 1 1 *", "2020-01-01 00:00", true},
+		{"0 0 1 1 *", "2020-02-01 00:00", false},
+		{"0 0 29 2 *", "2020-02-29 00:00", true},
+		// With both day fields restricted either of them matches
+		{"0 0 15 * 1", "2019-06-03 00:00", true},
+		{"0 0 15 * 1", "2019-06-15 00:00", true},
+		{"0 0 15 * 1", "2019-06-16 00:00", false},
+		// With one of them unrestricted only the other one counts
+		{"0 0 15 * *", "2019-06-03 00:00", false},
+		{"0 0 * * 1", "2019-06-15 00:00", false},
+		{"0 0 */2 * 1", "2019-06-04 00:00", false},
+		{"0 0 */2 * 1", "2019-06-05 00:00", false},
+		{"0 0 */2 * 1", "2019-06-03 00:00", true},
+		{"@weekly", "2019-06-09 00:00", true},
+		{"@weekly", "2019-06-03 00:00", false},
+		{"@monthly", "2019-06-01 00:00", true},
+		{"@hourly", "2019-06-03 13:00", true},
+		{"@hourly", "2019-06-03 13:30", false},
+	}
+	for _, test := range tests {
+		schedule, err := parseSchedule(test.spec)
+		if err != nil {
+			t.Errorf("parseSchedule(%q) unexpected error %s", test.spec, err)
+			continue
+		}
+		if got := schedule.matches(at(test.time)); got != test.want {
+			t.Errorf("%q at %s: got %t, want %t", test.spec, test.time, got, test.want)
+		}
+	}
+}
+
+func TestClaimScheduledRuns(t *testing.T) {
+	r := Resource{K8sClient: k8sfake.NewSimpleClientset()}
+	nightly := Webhook{Name: "nightly"}
+	hourly := Webhook{Name: "hourly"}
+
+	claimed, err := r.claimScheduledRuns("default", []Webhook{nightly, hourly}, "2019-06-03T02:00Z")
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("got %v, %v, want both webhooks claimed", claimed, err)
+	}
+	// Another replica handling the same minute runs nothing
+	claimed, err = r.claimScheduledRuns("default", []Webhook{nightly, hourly}, "2019-06-03T02:00Z")
+	if err != nil || len(claimed) != 0 {
+		t.Fatalf("got %v, %v, want nothing claimed twice", claimed, err)
+	}
+	claimed, err = r.claimScheduledRuns("default", []Webhook{hourly}, "2019-06-03T03:00Z")
+	if err != nil || len(claimed) != 1 || claimed[0].Name != "hourly" {
+		t.Fatalf("got %v, %v, want the next minute claimed", claimed, err)
+	}
+}
@@ -0,0 +1,128 @@
+package endpoints
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Headers GitHub signs deliveries in, with an HMAC of the payload keyed by the webhook secret
+const githubSignatureHeader = "X-Hub-Signature"
+const githubSignature256Header = "X-Hub-Signature-256"
+
+// Key of a webhook's secret holding the secret GitHub signs its deliveries with, the GitHubSource sets it on the repository's hook
+const secretTokenKey = "secretToken"
+
+// GitHub sends deliveries of at most 25MB, anything past that can't have been signed by it
+const maxGitHubDelivery = 25 * 1024 * 1024
+
+// The headers of a verified delivery handed to the listener, any others the sender set are dropped
+var forwardedDeliveryHeaders = []string{"Content-Type", "User-Agent", githubEventHeader, githubDeliveryHeader}
+
+// validSignature returns true if the headers carry a signature of the payload made with the secret,
+// X-Hub-Signature-256 when there is one and otherwise the SHA-1 X-Hub-Signature of older GitHub Enterprise versions
+func validSignature(payload []byte, secret string, header http.Header) bool {
+	signature, prefix, newHash := header.Get(githubSignature256Header), "sha256=", sha256.New
+	if signature == "" {
+		signature, prefix, newHash = header.Get(githubSignatureHeader), "sha1=", func() hash.Hash { return sha1.New() }
+	}
+	if secret == "" || !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(payload)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// getSecretToken returns the secret a webhook's deliveries are signed with
+func (r Resource) getSecretToken(webhook Webhook) (string, error) {
+	namespace := webhook.Namespace
+	if namespace == "" {
+		namespace = getPipelineRunNamespace()
+	}
+	secret, err := r.K8sClient.CoreV1().Secrets(namespace).Get(webhook.AccessTokenRef, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(secret.Data[secretTokenKey])), nil
+}
+
+// verifyDelivery checks that an event is signed with the secret token of a webhook in the namespace for its repository
+func (r Resource) verifyDelivery(payload []byte, header http.Header, namespace string) error {
+	var event struct {
+		Repository struct {
+			HTMLURL string `json:"html_url"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil || event.Repository.HTMLURL == "" {
+		return errors.New("the event names no repository to check its signature for")
+	}
+	for _, webhook := range r.readGitHubWebhook(namespace) {
+		if webhook.GitRepositoryURL != event.Repository.HTMLURL {
+			continue
+		}
+		webhook.Namespace = namespace
+		secretToken, err := r.getSecretToken(webhook)
+		if err != nil {
+			return fmt.Errorf("could not get the secret token of webhook %s: %s", webhook.Name, err)
+		}
+		if validSignature(payload, secretToken, header) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the event for %s is not signed with the secret token of a webhook for the repository", event.Repository.HTMLURL)
+}
+
+// receiveGitHubDelivery takes an event GitHub delivered straight to the webhook service, as to a GitHub App's own webhook,
+// and hands it to the listener once it is found to be signed for its repository. Every delivery is checked whatever headers
+// it comes with, the listener itself only takes events from within the cluster
+func (r Resource) receiveGitHubDelivery(request *restful.Request, response *restful.Response) {
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	payload, err := ioutil.ReadAll(io.LimitReader(request.Request.Body, maxGitHubDelivery))
+	if err != nil {
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	deliveryID := request.HeaderParameter(githubDeliveryHeader)
+	if err := r.verifyDelivery(payload, request.Request.Header, namespace); err != nil {
+		logger.Warnf("rejecting delivery %s: %s", deliveryID, err)
+		RespondError(response, err, http.StatusUnauthorized)
+		return
+	}
+
+	event, err := http.NewRequest(http.MethodPost, getListenerURL(namespace), bytes.NewReader(payload))
+	if err != nil {
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	for _, header := range forwardedDeliveryHeaders {
+		if value := request.Request.Header.Get(header); value != "" {
+			event.Header.Set(header, value)
+		}
+	}
+	if err := forwardToListener(event, response); err != nil {
+		logger.Errorf("could not hand delivery %s to the listener, error: %s", deliveryID, err)
+		RespondError(response, err, http.StatusBadGateway)
+	}
+}
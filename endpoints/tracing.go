@@ -0,0 +1,129 @@
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceIDAnnotation records the trace of the event a PipelineRun was created for
+const traceIDAnnotation = "webhooks.tekton.dev/trace-id"
+
+// Prefix CloudEvents binary mode puts on the HTTP headers of extension attributes such as traceparent
+const cloudEventHeaderPrefix = "Ce-"
+
+// Spans are started through the global provider, which does nothing until InitTracing sets one up
+var tracer = otel.Tracer("github.com/ncskier/webhook-extension/endpoints")
+
+/* InitTracing exports traces over OTLP/HTTP to the collector at OTEL_EXPORTER_OTLP_ENDPOINT, e.g. http://collector:4318.
+Tracing is off when no endpoint is set, trace context from events is still carried onto PipelineRuns.
+The returned function flushes the spans not yet exported */
+func InitTracing(serviceName string) func() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func() {}
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		logger.Errorf("could not create the trace exporter, tracing is off: %s", err)
+		return func() {}
+	}
+	serviceResource, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		serviceResource = resource.Default()
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(serviceResource))
+	otel.SetTracerProvider(provider)
+	logger.Infof("Exporting traces as %s", serviceName)
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			logger.Errorf("could not flush traces: %s", err)
+		}
+	}
+}
+
+// cloudEventCarrier reads the trace context of a CloudEvent from its headers, where the distributed tracing
+// extension sends it as Ce-Traceparent, falling back to the W3C traceparent header
+type cloudEventCarrier http.Header
+
+func (c cloudEventCarrier) Get(key string) string {
+	if value := http.Header(c).Get(cloudEventHeaderPrefix + key); value != "" {
+		return value
+	}
+	return http.Header(c).Get(key)
+}
+
+func (c cloudEventCarrier) Set(key, value string) {
+	http.Header(c).Set(key, value)
+}
+
+func (c cloudEventCarrier) Keys() []string {
+	keys := []string{}
+	for key := range c {
+		keys = append(keys, strings.ToLower(strings.TrimPrefix(key, cloudEventHeaderPrefix)))
+	}
+	return keys
+}
+
+// startEventSpan starts the span covering the handling of an event, continuing the trace of the CloudEvent if it has one
+func (r Resource) startEventSpan(request *restful.Request) (Resource, trace.Span) {
+	r.ctx = otel.GetTextMapPropagator().Extract(context.Background(), cloudEventCarrier(request.Request.Header))
+	r, span := r.startSpan("handleWebhook", trace.WithSpanKind(trace.SpanKindServer))
+	if span.SpanContext().HasTraceID() {
+		r = r.withFields("traceID", span.SpanContext().TraceID().String())
+	}
+	return r, span
+}
+
+// startSpan returns a copy of the Resource whose spans are children of a new span, which the caller must end
+func (r Resource) startSpan(name string, options ...trace.SpanStartOption) (Resource, trace.Span) {
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracer.Start(ctx, name, options...)
+	r.ctx = ctx
+	return r, span
+}
+
+// endSpan ends a span, marking it failed when there is an error
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceID returns the ID of the trace being recorded, or "" outside of one
+func (r Resource) traceID() string {
+	if r.ctx == nil {
+		return ""
+	}
+	spanContext := trace.SpanContextFromContext(r.ctx)
+	if !spanContext.HasTraceID() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
+
+// Attributes naming the webhook and commit a span is about
+func buildAttributes(webhook Webhook, buildInformation BuildInformation) trace.SpanStartOption {
+	return trace.WithAttributes(
+		attribute.String("webhook", webhook.Name),
+		attribute.String("repo", buildInformation.REPOURL),
+		attribute.String("commit", buildInformation.COMMITID),
+	)
+}
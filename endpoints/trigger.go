@@ -0,0 +1,133 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+)
+
+// triggeredByAnnotation records who manually triggered a PipelineRun
+const triggeredByAnnotation = "webhooks.tekton.dev/triggered-by"
+
+// Value of the trigger label on PipelineRuns started through the trigger API rather than by an event
+const manualTrigger = "manual"
+
+// Headers an authenticating proxy in front of the webhook service sets to the requesting user
+var userHeaders = []string{"X-Remote-User", "X-Forwarded-User"}
+
+// TriggerRequest asks for a webhook's pipeline to run against a ref or commit, with optional param overrides
+type TriggerRequest struct {
+	Ref    string            `json:"ref,omitempty"`
+	Commit string            `json:"commit,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// TriggerResponse names the PipelineRun a trigger created, or says it was queued behind a concurrency limit
+type TriggerResponse struct {
+	PipelineRun string `json:"pipelinerun,omitempty"`
+	Queued      bool   `json:"queued,omitempty"`
+}
+
+// Returns the requesting user as set by an authenticating proxy, or ""
+func getRequestingUser(request *restful.Request) string {
+	for _, header := range userHeaders {
+		if user := request.HeaderParameter(header); user != "" {
+			return user
+		}
+	}
+	return ""
+}
+
+// buildInformationForTrigger resolves the requested ref or commit to the information to build it
+func (r Resource) buildInformationForTrigger(webhook Webhook, trigger TriggerRequest) (BuildInformation, error) {
+	ref := trigger.Ref
+	if ref == "" {
+		ref = trigger.Commit
+	}
+	if ref == "" {
+		return BuildInformation{}, errors.New("a ref or commit is required, but none was given")
+	}
+	_, _, gitRepo, err := getGitValues(webhook.GitRepositoryURL)
+	if err != nil {
+		return BuildInformation{}, err
+	}
+	provider, err := r.getGitProvider(webhook)
+	if err != nil {
+		return BuildInformation{}, err
+	}
+	commit, err := provider.GetCommit(webhook.GitRepositoryURL, strings.TrimPrefix(ref, "refs/heads/"))
+	if err != nil {
+		return BuildInformation{}, fmt.Errorf("could not resolve %s: %s", ref, err)
+	}
+	if !commitIDPattern.MatchString(commit.SHA) {
+		return BuildInformation{}, fmt.Errorf("%s resolved to an invalid commit ID %s", ref, commit.SHA)
+	}
+
+	buildInformation := BuildInformation{
+		REPOURL:       webhook.GitRepositoryURL,
+		SHORTID:       commit.SHA[0:7],
+		COMMITID:      commit.SHA,
+		REPONAME:      strings.TrimSuffix(gitRepo, ".git"),
+		TIMESTAMP:     getDateTimeAsString(),
+		COMMITMESSAGE: commit.Message,
+		EVENTTYPE:     manualTrigger,
+		TRIGGER:       manualTrigger,
+		PARAMS:        trigger.Params,
+	}
+	if trigger.Ref != "" {
+		if strings.HasPrefix(trigger.Ref, tagRefPrefix) {
+			buildInformation.TAG = strings.TrimPrefix(trigger.Ref, tagRefPrefix)
+		} else {
+			buildInformation.BRANCH = strings.TrimPrefix(trigger.Ref, branchRefPrefix)
+		}
+	}
+	return buildInformation, nil
+}
+
+// triggerWebhook starts a webhook's pipeline on demand, bypassing the filters that apply to events
+func (r Resource) triggerWebhook(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	trigger := TriggerRequest{}
+	if err := request.ReadEntity(&trigger); err != nil {
+		r.log().Errorf("Got an error trying to read request to trigger webhook %s: %s", name, err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	webhook, ok := r.readGitHubWebhook(namespace)[name]
+	if !ok {
+		RespondErrorMessage(response, fmt.Sprintf("no webhook named %s in namespace %s", name, namespace), http.StatusNotFound)
+		return
+	}
+
+	buildInformation, err := r.buildInformationForTrigger(webhook, trigger)
+	if err != nil {
+		r.log().Errorf("could not trigger webhook %s: %s", name, err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	if buildInformation.TAG != "" && webhook.ReleasePipeline == "" {
+		// Without a release pipeline a tag is built like any other ref
+		buildInformation.TAG = ""
+	}
+	buildInformation.TRIGGEREDBY = getRequestingUser(request)
+	r.log().Infof("%s triggered webhook %s for %s at %s", buildInformation.TRIGGEREDBY, name, buildInformation.REPOURL, buildInformation.COMMITID)
+
+	pipelineRunName, err := r.withFields("webhook", webhook.Name).startPipelineRun(webhook, buildInformation, getPipelineRunNamespace())
+	if err != nil {
+		RespondErrorAndMessage(response, err, fmt.Sprintf("could not start the PipelineRun for webhook %s", name), http.StatusInternalServerError)
+		return
+	}
+	if pipelineRunName == "" {
+		response.WriteHeaderAndJson(http.StatusAccepted, TriggerResponse{Queued: true}, restful.MIME_JSON)
+		return
+	}
+	response.WriteHeaderAndJson(http.StatusCreated, TriggerResponse{PipelineRun: pipelineRunName}, restful.MIME_JSON)
+}
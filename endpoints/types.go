@@ -1,10 +1,11 @@
 package endpoints
 
 import (
-	"log"
+	"context"
 
 	eventsrcclientset "github.com/knative/eventing-sources/pkg/client/clientset/versioned"
 	tektoncdclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	"go.uber.org/zap"
 	k8sclientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -14,6 +15,17 @@ type Resource struct {
 	EventSrcClient eventsrcclientset.Interface
 	TektonClient   tektoncdclientset.Interface
 	K8sClient      k8sclientset.Interface
+	GitProvider    GitProviderFactory
+	// Set while handling a dry run, PipelineRuns are then rendered into it rather than created
+	dryRun *DryRunResponse
+	// Set while handling an event, what happened to it is recorded into it for the event history
+	delivery *Delivery
+	// Logs with the fields of the event being handled, see log
+	logger *zap.SugaredLogger
+	// Carries the span of the event being handled, see startSpan
+	ctx context.Context
+	// Set while requeueing a dead letter, a failure updates it rather than dead lettering the event again
+	requeuedDeadLetter string
 }
 
 // NewResource returns a new Resource instantiated with its clientsets
@@ -21,28 +33,28 @@ func NewResource() (Resource, error) {
 	// Get cluster config
 	config, err := rest.InClusterConfig()
 	if err != nil {
-		log.Fatalf("Error getting in cluster config: %s", err.Error())
+		logger.Fatalf("Error getting in cluster config: %s", err.Error())
 		return Resource{}, err
 	}
 
 	// Setup event source client
 	eventSrcClient, err := eventsrcclientset.NewForConfig(config)
 	if err != nil {
-		log.Printf("Error building event source client: %s", err.Error())
+		logger.Errorf("Error building event source client: %s", err.Error())
 		return Resource{}, err
 	}
 
 	// Setup tektoncd client
 	tektonClient, err := tektoncdclientset.NewForConfig(config)
 	if err != nil {
-		log.Printf("Error building tekton clientset: %s", err.Error())
+		logger.Errorf("Error building tekton clientset: %s", err.Error())
 		return Resource{}, err
 	}
 
 	// Setup k8s client
 	k8sClient, err := k8sclientset.NewForConfig(config)
 	if err != nil {
-		log.Printf("Error building k8s clientset: %s", err.Error())
+		logger.Errorf("Error building k8s clientset: %s", err.Error())
 		return Resource{}, err
 	}
 
@@ -50,6 +62,7 @@ func NewResource() (Resource, error) {
 		K8sClient:      k8sClient,
 		TektonClient:   tektonClient,
 		EventSrcClient: eventSrcClient,
+		GitProvider:    NewGitHubProvider,
 	}
 	return r, nil
 }
@@ -0,0 +1,83 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	gh "gopkg.in/go-playground/webhooks.v3/github"
+)
+
+const branchRefPrefix = "refs/heads/"
+
+// Pushes deleting a branch are their own kind of event, they can start a webhook's cleanup pipeline
+const branchDeleteEvent = "branch_delete"
+
+var commitIDPattern = regexp.MustCompile("^[0-9a-f]{7,40}$")
+
+// Events are checked before use so that missing fields such as a null head commit are rejected rather than panicking
+func validatePushPayload(payload gh.PushPayload) error {
+	if payload.Repository.URL == "" {
+		return errors.New("push event has no repository URL")
+	}
+	if strings.HasPrefix(payload.Ref, tagRefPrefix) {
+		return nil
+	}
+	if !strings.HasPrefix(payload.Ref, branchRefPrefix) {
+		return fmt.Errorf("push event has unsupported ref %q", payload.Ref)
+	}
+	if payload.Deleted {
+		if !commitIDPattern.MatchString(payload.Before) {
+			return fmt.Errorf("branch delete event has invalid before commit %q", payload.Before)
+		}
+		return nil
+	}
+	if !commitIDPattern.MatchString(payload.HeadCommit.ID) {
+		return fmt.Errorf("push event has invalid head commit %q", payload.HeadCommit.ID)
+	}
+	return nil
+}
+
+func validatePullRequestPayload(payload gh.PullRequestPayload) error {
+	if payload.Repository.HTMLURL == "" {
+		return errors.New("pull request event has no repository URL")
+	}
+	if payload.Number <= 0 {
+		return fmt.Errorf("pull request event has invalid number %d", payload.Number)
+	}
+	if !commitIDPattern.MatchString(payload.PullRequest.Head.Sha) {
+		return fmt.Errorf("pull request event has invalid head commit %q", payload.PullRequest.Head.Sha)
+	}
+	return nil
+}
+
+func validateReleasePayload(payload gh.ReleasePayload) error {
+	if payload.Repository.HTMLURL == "" {
+		return errors.New("release event has no repository URL")
+	}
+	if payload.Release.TagName == "" {
+		return errors.New("release event has no tag")
+	}
+	return nil
+}
+
+func validateCheckRunPayload(payload checkRunPayload) error {
+	if payload.Repository.HTMLURL == "" {
+		return errors.New("check run event has no repository URL")
+	}
+	if !commitIDPattern.MatchString(payload.CheckRun.HeadSHA) {
+		return fmt.Errorf("check run event has invalid head commit %q", payload.CheckRun.HeadSHA)
+	}
+	return nil
+}
+
+func validateIssueCommentPayload(payload issueCommentPayload) error {
+	if payload.Repository.HTMLURL == "" {
+		return errors.New("issue comment event has no repository URL")
+	}
+	if payload.Issue.Number <= 0 {
+		return fmt.Errorf("issue comment event has invalid issue number %d", payload.Issue.Number)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package endpoints
+
+import (
+	"testing"
+
+	gh "gopkg.in/go-playground/webhooks.v3/github"
+)
+
+const testCommitID = "0123456789abcdef0123456789abcdef01234567"
+
+func TestValidatePushPayload(t *testing.T) {
+	valid := func() gh.PushPayload {
+		payload := gh.PushPayload{Ref: "refs/heads/master"}
+		payload.Repository.URL = "https://github.com/owner/repo"
+		payload.HeadCommit.ID = testCommitID
+		return payload
+	}
+	tests := []struct {
+		name   string
+		modify func(*gh.PushPayload)
+		valid  bool
+	}{
+		{"branch push", func(*gh.PushPayload) {}, true},
+		{"no repository URL", func(p *gh.PushPayload) { p.Repository.URL = "" }, false},
+		{"tag push without head commit", func(p *gh.PushPayload) { p.Ref = "refs/tags/v1.0.0"; p.HeadCommit.ID = "" }, true},
+		{"unsupported ref", func(p *gh.PushPayload) { p.Ref = "refs/notes/commits" }, false},
+		{"null head commit", func(p *gh.PushPayload) { p.HeadCommit.ID = "" }, false},
+		{"short head commit", func(p *gh.PushPayload) { p.HeadCommit.ID = "abc" }, false},
+		{"uppercase head commit", func(p *gh.PushPayload) { p.HeadCommit.ID = "0123456789ABCDEF" }, false},
+		{"branch deleted", func(p *gh.PushPayload) { p.Deleted = true; p.HeadCommit.ID = ""; p.Before = testCommitID }, true},
+		{"branch deleted without before", func(p *gh.PushPayload) { p.Deleted = true; p.HeadCommit.ID = "" }, false},
+	}
+	for _, test := range tests {
+		payload := valid()
+		test.modify(&payload)
+		if err := validatePushPayload(payload); (err == nil) != test.valid {
+			t.Errorf("%s: got error %v, want valid %t", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestValidatePullRequestPayload(t *testing.T) {
+	valid := func() gh.PullRequestPayload {
+		payload := gh.PullRequestPayload{Number: 1}
+		payload.Repository.HTMLURL = "https://github.com/owner/repo"
+		payload.PullRequest.Head.Sha = testCommitID
+		return payload
+	}
+	tests := []struct {
+		name   string
+		modify func(*gh.PullRequestPayload)
+		valid  bool
+	}{
+		{"pull request", func(*gh.PullRequestPayload) {}, true},
+		{"no repository URL", func(p *gh.PullRequestPayload) { p.Repository.HTMLURL = "" }, false},
+		{"no number", func(p *gh.PullRequestPayload) { p.Number = 0 }, false},
+		{"no head commit", func(p *gh.PullRequestPayload) { p.PullRequest.Head.Sha = "" }, false},
+	}
+	for _, test := range tests {
+		payload := valid()
+		test.modify(&payload)
+		if err := validatePullRequestPayload(payload); (err == nil) != test.valid {
+			t.Errorf("%s: got error %v, want valid %t", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestValidateReleasePayload(t *testing.T) {
+	payload := gh.ReleasePayload{}
+	if err := validateReleasePayload(payload); err == nil {
+		t.Error("expected an error for a release without a repository URL")
+	}
+	payload.Repository.HTMLURL = "https://github.com/owner/repo"
+	if err := validateReleasePayload(payload); err == nil {
+		t.Error("expected an error for a release without a tag")
+	}
+	payload.Release.TagName = "v1.0.0"
+	if err := validateReleasePayload(payload); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestValidateCheckRunPayload(t *testing.T) {
+	payload := checkRunPayload{}
+	payload.Repository.HTMLURL = "https://github.com/owner/repo"
+	if err := validateCheckRunPayload(payload); err == nil {
+		t.Error("expected an error for a check run without a head commit")
+	}
+	payload.CheckRun.HeadSHA = testCommitID
+	if err := validateCheckRunPayload(payload); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
+
+func TestValidateIssueCommentPayload(t *testing.T) {
+	payload := issueCommentPayload{}
+	payload.Repository.HTMLURL = "https://github.com/owner/repo"
+	if err := validateIssueCommentPayload(payload); err == nil {
+		t.Error("expected an error for a comment without an issue number")
+	}
+	payload.Issue.Number = 3
+	if err := validateIssueCommentPayload(payload); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+}
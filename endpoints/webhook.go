@@ -4,113 +4,250 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 
 	restful "github.com/emicklei/go-restful"
 	eventapi "github.com/knative/eventing-sources/pkg/apis/sources/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // Webhook stores the webhook information
 type Webhook struct {
-	Name                 string `json:"name"`
-	Namespace            string `json:"namespace"`
-	ServiceAccount       string `json:"serviceaccount,omitempty"`
-	GitRepositoryURL     string `json:"gitrepositoryurl"`
-	AccessTokenRef       string `json:"accesstoken"`
-	Pipeline             string `json:"pipeline"`
-	RegistrySecret       string `json:"registrysecret,omitempty"`
-	HelmSecret           string `json:"helmsecret,omitempty"`
-	RepositorySecretName string `json:"repositorysecretname,omitempty"`
+	Name                    string            `json:"name"`
+	Namespace               string            `json:"namespace"`
+	ServiceAccount          string            `json:"serviceaccount,omitempty"`
+	GitRepositoryURL        string            `json:"gitrepositoryurl"`
+	AccessTokenRef          string            `json:"accesstoken"`
+	Pipeline                string            `json:"pipeline"`
+	RegistrySecret          string            `json:"registrysecret,omitempty"`
+	HelmSecret              string            `json:"helmsecret,omitempty"`
+	RepositorySecretName    string            `json:"repositorysecretname,omitempty"`
+	CancelSuperseded        bool              `json:"cancelsuperseded,omitempty"`
+	MaxConcurrentRuns       int               `json:"maxconcurrentruns,omitempty"`
+	SkipDirectives          []string          `json:"skipdirectives,omitempty"`
+	IgnoreSkipDirectives    bool              `json:"ignoreskipdirectives,omitempty"`
+	IncludePaths            []string          `json:"includepaths,omitempty"`
+	ExcludePaths            []string          `json:"excludepaths,omitempty"`
+	ChatOpsUsers            []string          `json:"chatopsusers,omitempty"`
+	ForkPolicy              string            `json:"forkpolicy,omitempty"`
+	UntrustedServiceAccount string            `json:"untrustedserviceaccount,omitempty"`
+	ReleasePipeline         string            `json:"releasepipeline,omitempty"`
+	ReleaseTrigger          string            `json:"releasetrigger,omitempty"`
+	CleanupPipeline         string            `json:"cleanuppipeline,omitempty"`
+	Schedule                string            `json:"schedule,omitempty"`
+	ScheduleBranch          string            `json:"schedulebranch,omitempty"`
+	Labels                  map[string]string `json:"labels,omitempty"`
+	DisableBadge            bool              `json:"disablebadge,omitempty"`
+	Notifications           []Notification    `json:"notifications,omitempty"`
+	CheckRuns               bool              `json:"checkruns,omitempty"`
+	CheckRunLogs            bool              `json:"checkrunlogs,omitempty"`
 }
 
 // ConfigMapName ... the name of the ConfigMap to create
 const ConfigMapName = "githubwebhook"
 
+// The Knative service GitHubSources send events to
+const listenerServiceName = "extension-knative-eventing-listener"
+
 func (r Resource) createWebhook(request *restful.Request, response *restful.Response) {
-	log.Printf("create webhook %v", request)
+	r.log().Info("create webhook")
 
 	webhook := Webhook{}
 	if err := request.ReadEntity(&webhook); err != nil {
-		log.Printf("Got an error trying to read request for webhook: %s", err)
+		r.log().Errorf("Got an error trying to read request for webhook: %s", err)
 		RespondError(response, err, http.StatusBadRequest)
 		return
 	}
 	namespace := webhook.Namespace
 	if namespace == "" {
 		err := errors.New("namespace is required, but none was given")
-		log.Printf("Error: %s", err.Error())
+		r.log().Errorf("Error: %s", err.Error())
 		RespondError(response, err, http.StatusBadRequest)
 		return
 	}
-	log.Printf("createGitHubSource: namespace: %s, entry: %v", namespace, webhook)
+	if err := validateWebhook(webhook); err != nil {
+		r.log().Errorf("Error: %s", err.Error())
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	r.log().Infow("createGitHubSource", "namespace", namespace, "webhook", webhook.Name, "repo", webhook.GitRepositoryURL)
+	spec, err := r.gitHubSourceSpec(webhook)
+	if err != nil {
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	entry := eventapi.GitHubSource{
+		ObjectMeta: metav1.ObjectMeta{Name: webhook.Name},
+		Spec:       spec,
+	}
+	_, err = r.EventSrcClient.SourcesV1alpha1().GitHubSources(namespace).Create(&entry)
+	if err != nil {
+		r.log().Errorf("error createGitHubSource: %+v", err)
+		r.recordWebhookEvent(webhook, corev1.EventTypeWarning, gitHubSourceFailedReason,
+			fmt.Sprintf("Could not create the GitHubSource for %s: %s", webhook.GitRepositoryURL, err))
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	webhooks := r.readGitHubWebhook(namespace)
+	webhooks[webhook.Name] = webhook
+	r.writeGitHubWebhook(namespace, webhooks)
+	r.recordWebhookEvent(webhook, corev1.EventTypeNormal, webhookCreatedReason,
+		fmt.Sprintf("Created webhook for %s running pipeline %s", webhook.GitRepositoryURL, webhook.Pipeline))
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// validateWebhook checks the settings of a webhook being created or updated
+func validateWebhook(webhook Webhook) error {
+	if webhook.Schedule != "" {
+		if _, err := parseSchedule(webhook.Schedule); err != nil {
+			return err
+		}
+	}
+	if err := validateCustomLabels(webhook.Labels); err != nil {
+		return err
+	}
+	return validateNotifications(webhook.Notifications)
+}
+
+// gitHubSourceSpec returns the spec of the GitHubSource that sends a webhook's events to the listener
+func (r Resource) gitHubSourceSpec(webhook Webhook) (eventapi.GitHubSourceSpec, error) {
 	pieces := strings.Split(webhook.GitRepositoryURL, "/")
 	if len(pieces) < 4 {
-		log.Printf("error createGitHubSource: GitRepositoryURL format: %+v", webhook.GitRepositoryURL)
-		RespondError(response, errors.New("GitRepositoryURL format error"), http.StatusBadRequest)
-		return
+		r.log().Errorf("error gitHubSourceSpec: GitRepositoryURL format: %+v", webhook.GitRepositoryURL)
+		return eventapi.GitHubSourceSpec{}, errors.New("GitRepositoryURL format error")
 	}
-	log.Printf("createGitHubSource: URL: %s, Owner-repo: %s",
+	r.log().Infof("gitHubSourceSpec: URL: %s, Owner-repo: %s",
 		strings.TrimSuffix(webhook.GitRepositoryURL, pieces[len(pieces)-2]+"/"+pieces[len(pieces)-1]),
 		pieces[len(pieces)-2]+"/"+strings.TrimSuffix(pieces[len(pieces)-1], ".git"))
-	entry := eventapi.GitHubSource{
-		ObjectMeta: metav1.ObjectMeta{Name: webhook.Name},
-		Spec: eventapi.GitHubSourceSpec{
-			OwnerAndRepository: pieces[len(pieces)-2] + "/" + strings.TrimSuffix(pieces[len(pieces)-1], ".git"),
-			EventTypes:         []string{"push", "pull_request"},
-			GitHubAPIURL:       strings.TrimSuffix(webhook.GitRepositoryURL, pieces[len(pieces)-2]+"/"+pieces[len(pieces)-1]) + "api/v3/",
-			AccessToken: eventapi.SecretValueFromSource{
-				SecretKeyRef: &corev1.SecretKeySelector{
-					Key: "accessToken",
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: webhook.AccessTokenRef,
-					},
+	return eventapi.GitHubSourceSpec{
+		OwnerAndRepository: pieces[len(pieces)-2] + "/" + strings.TrimSuffix(pieces[len(pieces)-1], ".git"),
+		EventTypes:         []string{"push", "pull_request", "issue_comment", "release", "check_run"},
+		GitHubAPIURL:       strings.TrimSuffix(webhook.GitRepositoryURL, pieces[len(pieces)-2]+"/"+pieces[len(pieces)-1]) + "api/v3/",
+		AccessToken: eventapi.SecretValueFromSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				Key: "accessToken",
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: webhook.AccessTokenRef,
 				},
 			},
-			SecretToken: eventapi.SecretValueFromSource{
-				SecretKeyRef: &corev1.SecretKeySelector{
-					Key: "secretToken",
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: webhook.AccessTokenRef,
-					},
+		},
+		SecretToken: eventapi.SecretValueFromSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				Key: "secretToken",
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: webhook.AccessTokenRef,
 				},
 			},
-			Sink: &corev1.ObjectReference{
-				APIVersion: "serving.knative.dev/v1alpha1",
-				Kind:       "Service",
-				Name:       "extension-knative-eventing-listener",
-			},
 		},
+		Sink: &corev1.ObjectReference{
+			APIVersion: "serving.knative.dev/v1alpha1",
+			Kind:       "Service",
+			Name:       listenerServiceName,
+		},
+	}, nil
+}
+
+// updateWebhook replaces the settings of a webhook, updating its GitHubSource for a new repository or access token
+func (r Resource) updateWebhook(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	r.log().Infow("update webhook", "namespace", namespace, "webhook", name)
+
+	webhook := Webhook{}
+	if err := request.ReadEntity(&webhook); err != nil {
+		r.log().Errorf("Got an error trying to read request to update webhook %s: %s", name, err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	webhook.Name, webhook.Namespace = name, namespace
+	if err := validateWebhook(webhook); err != nil {
+		r.log().Errorf("Error: %s", err.Error())
+		RespondError(response, err, http.StatusBadRequest)
+		return
 	}
-	_, err := r.EventSrcClient.SourcesV1alpha1().GitHubSources(namespace).Create(&entry)
+	webhooks := r.readGitHubWebhook(namespace)
+	if _, ok := webhooks[name]; !ok {
+		RespondErrorMessage(response, fmt.Sprintf("no webhook named %s in namespace %s", name, namespace), http.StatusNotFound)
+		return
+	}
+	spec, err := r.gitHubSourceSpec(webhook)
 	if err != nil {
-		log.Printf("error createGitHubSource: %+v", err)
 		RespondError(response, err, http.StatusBadRequest)
 		return
 	}
+	sources := r.EventSrcClient.SourcesV1alpha1().GitHubSources(namespace)
+	source, err := sources.Get(name, metav1.GetOptions{})
+	if err == nil {
+		source.Spec = spec
+		_, err = sources.Update(source)
+	}
+	if err != nil {
+		r.log().Errorf("error updateGitHubSource: %+v", err)
+		r.recordWebhookEvent(webhook, corev1.EventTypeWarning, gitHubSourceFailedReason,
+			fmt.Sprintf("Could not update the GitHubSource for %s: %s", webhook.GitRepositoryURL, err))
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	webhooks[name] = webhook
+	r.writeGitHubWebhook(namespace, webhooks)
+	r.recordWebhookEvent(webhook, corev1.EventTypeNormal, webhookUpdatedReason,
+		fmt.Sprintf("Updated webhook for %s running pipeline %s", webhook.GitRepositoryURL, webhook.Pipeline))
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// deleteWebhook deletes a webhook and its GitHubSource, which removes the hook from the repository
+func (r Resource) deleteWebhook(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	namespace := request.QueryParameter("namespace")
+	if namespace == "" {
+		RespondError(response, errors.New("namespace is required, but none was given"), http.StatusBadRequest)
+		return
+	}
+	r.log().Infow("delete webhook", "namespace", namespace, "webhook", name)
+
 	webhooks := r.readGitHubWebhook(namespace)
-	webhooks[webhook.Name] = webhook
+	webhook, ok := webhooks[name]
+	if !ok {
+		RespondErrorMessage(response, fmt.Sprintf("no webhook named %s in namespace %s", name, namespace), http.StatusNotFound)
+		return
+	}
+	webhook.Namespace = namespace
+	err := r.EventSrcClient.SourcesV1alpha1().GitHubSources(namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		r.log().Errorf("error deleteGitHubSource: %+v", err)
+		r.recordWebhookEvent(webhook, corev1.EventTypeWarning, gitHubSourceFailedReason,
+			fmt.Sprintf("Could not delete the GitHubSource for %s: %s", webhook.GitRepositoryURL, err))
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	delete(webhooks, name)
 	r.writeGitHubWebhook(namespace, webhooks)
+	r.recordWebhookEvent(webhook, corev1.EventTypeNormal, webhookDeletedReason,
+		fmt.Sprintf("Deleted webhook for %s", webhook.GitRepositoryURL))
 	response.WriteHeader(http.StatusNoContent)
 }
 
 // retrieve retistry secret, helm secret and pipeline name for the github url
 func (r Resource) getGitHubWebhook(gitrepourl string, namespace string) (Webhook, error) {
-	log.Printf("getgitHubSource: getSecrets: namespace: %s, repositoryurl: %v", namespace, gitrepourl)
+	r.log().Debugf("getgitHubSource: getSecrets: namespace: %s, repositoryurl: %v", namespace, gitrepourl)
 
 	crds, err := r.EventSrcClient.SourcesV1alpha1().GitHubSources(namespace).List(metav1.ListOptions{})
 	if err != nil {
-		log.Printf("got an error trying to get githubsources: %s", err)
+		r.log().Errorf("got an error trying to get githubsources: %s", err)
 		return Webhook{}, err
 	}
 	sources := r.readGitHubWebhook(namespace)
 	newsources := make(map[string]Webhook)
 	// Create a new crd if it does not exist
 	for _, crd := range crds.Items {
-		log.Printf("GitHubSource: %s", crd.ObjectMeta.Name)
+		r.log().Debugf("GitHubSource: %s", crd.ObjectMeta.Name)
 		source, ok := sources[crd.ObjectMeta.Name]
 		if !ok {
 			source = Webhook{Name: crd.ObjectMeta.Name}
@@ -139,11 +276,11 @@ func (r Resource) getGitHubWebhook(gitrepourl string, namespace string) (Webhook
 // }
 
 func (r Resource) readGitHubWebhook(namespace string) map[string]Webhook {
-	log.Printf("readGitHubSource")
+	r.log().Debugf("readGitHubSource")
 	configMapClient := r.K8sClient.CoreV1().ConfigMaps(namespace)
 	configMap, err := configMapClient.Get(ConfigMapName, metav1.GetOptions{})
 	if err != nil {
-		log.Printf("readGitHubSource: %s", err)
+		r.log().Warnf("readGitHubSource: %s", err)
 		configMap = &corev1.ConfigMap{}
 		configMap.BinaryData = make(map[string][]byte)
 	}
@@ -152,17 +289,17 @@ func (r Resource) readGitHubWebhook(namespace string) map[string]Webhook {
 	if ok {
 		err = json.Unmarshal(raw, &result)
 		if err != nil {
-			log.Printf("readGitHubSource: %s", err)
+			r.log().Errorf("readGitHubSource: %s", err)
 		}
 	} else {
 		result = make(map[string]Webhook)
 	}
-	log.Printf("readGitHubSource: %v", result)
+	r.log().Debugf("readGitHubSource: %v", webhookNames(result))
 	return result
 }
 
 func (r Resource) writeGitHubWebhook(namespace string, source map[string]Webhook) {
-	log.Printf("writeGitHubSource: nameSpace: %s, %+v", namespace, source)
+	r.log().Debugf("writeGitHubSource: nameSpace: %s, %v", namespace, webhookNames(source))
 	configMapClient := r.K8sClient.CoreV1().ConfigMaps(namespace)
 	configMap, err := configMapClient.Get(ConfigMapName, metav1.GetOptions{})
 	var create = false
@@ -178,18 +315,18 @@ func (r Resource) writeGitHubWebhook(namespace string, source map[string]Webhook
 	}
 	buf, err := json.Marshal(source)
 	if err != nil {
-		log.Printf("writeGitHubSource: %s", err)
+		r.log().Errorf("writeGitHubSource: %s", err)
 	}
 	configMap.BinaryData["GitHubSource"] = buf
 	if create {
 		_, err = configMapClient.Create(configMap)
 		if err != nil {
-			log.Printf("writeGitHubSource: %s", err)
+			r.log().Errorf("writeGitHubSource: %s", err)
 		}
 	} else {
 		_, err = configMapClient.Update(configMap)
 		if err != nil {
-			log.Printf("writeGitHubSource: %s", err)
+			r.log().Errorf("writeGitHubSource: %s", err)
 		}
 	}
 
@@ -197,23 +334,22 @@ func (r Resource) writeGitHubWebhook(namespace string, source map[string]Webhook
 
 // RespondError ...
 func RespondError(response *restful.Response, err error, statusCode int) {
-	log.Printf("[RespondError] Error: %s", err.Error())
-	log.Printf("Response is %v\n", *response)
+	logger.Warnf("[RespondError] Error: %s", err.Error())
 	response.AddHeader("Content-Type", "text/plain")
 	response.WriteError(statusCode, err)
 }
 
 // RespondErrorMessage ...
 func RespondErrorMessage(response *restful.Response, message string, statusCode int) {
-	log.Printf("[RespondErrorMessage] Message: %s", message)
+	logger.Warnf("[RespondErrorMessage] Message: %s", message)
 	response.AddHeader("Content-Type", "text/plain")
 	response.WriteErrorString(statusCode, message)
 }
 
 // RespondErrorAndMessage ...
 func RespondErrorAndMessage(response *restful.Response, err error, message string, statusCode int) {
-	log.Printf("[RespondErrorAndMessage] Error: %s", err.Error())
-	log.Printf("Message is %x\n", message)
+	logger.Warnf("[RespondErrorAndMessage] Error: %s", err.Error())
+	logger.Infof("Message is %x\n", message)
 	response.AddHeader("Content-Type", "text/plain")
 	response.WriteErrorString(statusCode, message)
 }
@@ -227,10 +363,22 @@ func WebhookWebService(r Resource) *restful.WebService {
 		Produces(restful.MIME_JSON, restful.MIME_JSON)
 
 	ws.Route(ws.POST("/").To(r.createWebhook))
+	ws.Route(ws.GET("/queue").To(r.getRunQueue))
+	ws.Route(ws.GET("/deadletters").To(r.getDeadLetters))
+	ws.Route(ws.POST("/deadletters/{id}/requeue").To(r.requeueDeadLetter))
+	ws.Route(ws.POST("/github").To(r.receiveGitHubDelivery))
+	ws.Route(ws.POST("/{name}/trigger").To(r.triggerWebhook))
+	ws.Route(ws.GET("/{name}/runs").To(r.getWebhookRuns))
+	ws.Route(ws.GET("/{name}/runs/{run}").To(r.getWebhookRun))
+	// Badges are embedded as images, image clients don't necessarily accept SVG by name
+	ws.Route(ws.GET("/{name}/badge.svg").To(r.getBadgeSVG).Produces("image/svg+xml", "*/*"))
+	ws.Route(ws.GET("/{name}/badge.json").To(r.getBadgeJSON))
+	ws.Route(ws.GET("/{name}/deliveries").To(r.getDeliveries))
+	ws.Route(ws.POST("/{name}/deliveries/{id}/replay").To(r.replayDelivery))
+	ws.Route(ws.PUT("/{name}").To(r.updateWebhook))
+	ws.Route(ws.DELETE("/{name}").To(r.deleteWebhook))
 	// ws.Route(ws.GET("/").To(r.getAllWebhooks))
 	// ws.Route(ws.GET("/{webhook-id}").To(r.getWebhook))
-	// ws.Route(ws.PUT("/{webhook-id}").To(r.updateWebhook))
-	// ws.Route(ws.DELETE("/{webhook-id}").To(r.deleteWebhook))
 
 	return ws
 }